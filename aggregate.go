@@ -0,0 +1,353 @@
+// Copyright (c) 2024 Yang,Zhong
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/logger"
+)
+
+type param struct {
+	v any
+}
+
+// Value bind v to the function as a parameter instead of the sql
+//
+//	Coalesce(SUM("price"), Value(0))
+func Value(v any) param {
+	return param{v: v}
+}
+
+func SUM(field any) Func {
+	return Func{Template: "SUM(%s)", Field: []any{field}}
+}
+
+func AVG(field any) Func {
+	return Func{Template: "AVG(%s)", Field: []any{field}}
+}
+
+// GroupConcat join the values of the group with sep, it's STRING_AGG on postgres and sqlserver
+func GroupConcat(field any, sep string) Func {
+	quoted := strings.ReplaceAll(strings.ReplaceAll(sep, "'", "''"), "%", "%%")
+	mysql := strings.ReplaceAll(strings.ReplaceAll(strings.ReplaceAll(sep, `\`, `\\`), "'", "''"), "%", "%%")
+	return Func{
+		Template: "GROUP_CONCAT(%s, '" + quoted + "')",
+		Field:    []any{field},
+		Dialects: map[string]string{
+			"mysql":     "GROUP_CONCAT(%s SEPARATOR '" + mysql + "')",
+			"postgres":  "STRING_AGG(%s, '" + quoted + "')",
+			"sqlserver": "STRING_AGG(%s, '" + quoted + "')",
+		},
+	}
+}
+
+// Coalesce return the first of the fields which isn't NULL, bind the defaults with Value
+func Coalesce(field any, fallbacks ...any) Func {
+	fields := append([]any{field}, fallbacks...)
+	return Func{Template: "COALESCE(" + strings.TrimSuffix(strings.Repeat("%s, ", len(fields)), ", ") + ")", Field: fields}
+}
+
+type caseWhen struct {
+	template string
+	fields   []any
+}
+
+// Case build the CASE WHEN function, the results which are neither Func, Field nor Value are bound
+// usage:
+//
+//	paid := SUM(Case().When(Field("price"), func(opts *MatchOptions, schema Schema) {
+//		opts.EQ(schema.Field("status"), "paid")
+//	}).Else(0))
+func Case() caseWhen {
+	return caseWhen{}
+}
+
+// When add the branch which results in then if all of the conds match
+func (c caseWhen) When(then any, conds ...MatchOption) caseWhen {
+	c.template += "WHEN %s THEN %s "
+	c.fields = append(append([]any{}, c.fields...), conds, caseResult(then))
+	return c
+}
+
+// Else end the function with the result of the records no branch matches
+func (c caseWhen) Else(v any) Func {
+	return Func{Template: "CASE " + c.template + "ELSE %s END", Field: append(append([]any{}, c.fields...), caseResult(v))}
+}
+
+// End end the function, the records no branch matches result in NULL
+func (c caseWhen) End() Func {
+	return Func{Template: "CASE " + c.template + "END", Field: append([]any{}, c.fields...)}
+}
+
+func caseResult(v any) any {
+	switch v.(type) {
+	case Func, field, param:
+		return v
+	}
+	return Value(v)
+}
+
+// compileFunc compile the function with the template of the dialect, the values and
+// the conditions of it are bound
+func (repo *dbrepo) compileFunc(schema Schema, f Func) (string, []any, error) {
+	template := f.Template
+	if t, ok := f.Dialects[repo.dialect()]; ok {
+		template = t
+	}
+	var args, values []any
+	for _, fi := range f.Field {
+		var str string
+		var vs []any
+		var err error
+		switch vl := fi.(type) {
+		case Func:
+			str, vs, err = repo.compileFunc(schema, vl)
+		case field:
+			str, vs, err = repo.compileField(schema, vl)
+		case string:
+			str = vl
+		case param:
+			str, vs = "?", []any{vl.v}
+		case []MatchOption:
+			if str, vs, err = repo.compileMatchOptions(schema, vl); err == nil && str == "" {
+				err = errors.New("CASE WHEN requires at least one condition")
+			}
+		default:
+			continue
+		}
+		if err != nil {
+			return "", nil, err
+		}
+		args, values = append(args, str), append(values, vs...)
+	}
+	return fmt.Sprintf(template, args...), values, nil
+}
+
+func (repo *dbrepo) compileField(schema Schema, f field) (string, []any, error) {
+	var ret string
+	var values []any
+	var err error
+	switch vl := f.Field.(type) {
+	case Func:
+		ret, values, err = repo.compileFunc(schema, vl)
+	case field:
+		ret, values, err = repo.compileField(schema, vl)
+	case string:
+		ret = schema.Quote(vl)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	return f.decorate(ret), values, nil
+}
+
+// render compile the expression by a repository of the db of schema and inline the values, the
+// schemas without db are rendered in no dialect
+func render(schema Schema, compile func(repo *dbrepo) (string, []any, error)) string {
+	repo := &dbrepo{}
+	if dbs, ok := schema.(*DBSchema); ok {
+		repo.db = dbs.DB
+	}
+	str, values, err := compile(repo)
+	if err != nil {
+		return fmt.Sprintf("%%!(ERROR=%v)", err)
+	}
+	if repo.db == nil || repo.db.Dialector == nil {
+		return logger.ExplainSQL(str, nil, "'", values...)
+	}
+	stmt := &gorm.Statement{DB: repo.db, Clauses: map[string]clause.Clause{}}
+	clause.Expr{SQL: str, Vars: values}.Build(stmt)
+	return repo.db.Dialector.Explain(stmt.SQL.String(), stmt.Vars...)
+}
+
+type aggregateItem struct {
+	fn   Func
+	into any
+}
+
+// Into scan the aggregate fn into v for Aggregate, wrap fn with Coalesce or scan into a sql.Null type
+// where the aggregate of no records is NULL
+func (m *Model) Into(fn Func, v any) *Model {
+	m.aggregates = append(m.aggregates, aggregateItem{fn: fn, into: v})
+	return m
+}
+
+// groups get the columns the model is grouped by, By is kept as it's written
+func (m *Model) groups() []any {
+	if m.Grp == nil {
+		return nil
+	}
+	var groups []any
+	if m.Grp.By != "" {
+		for _, by := range strings.Split(m.Grp.By, ",") {
+			groups = append(groups, rawColumn(strings.TrimSpace(by)))
+		}
+	}
+	return append(groups, m.Grp.Exprs...)
+}
+
+// rawColumn is a column of Group which is selected as it's written
+type rawColumn string
+
+func (m *Model) checkAggregates() error {
+	if len(m.aggregates) == 0 {
+		return fmt.Errorf("aggregate requires at least one aggregate, see Model.Into")
+	}
+	if len(m.Flds) > 0 || len(m.Joins) > 0 {
+		return fmt.Errorf("%w: fields and joins of the aggregated model", ErrUnsupported)
+	}
+	groups := len(m.groups())
+	for _, item := range m.aggregates {
+		rv := reflect.ValueOf(item.into)
+		if rv.Kind() != reflect.Ptr || rv.IsNil() {
+			return fmt.Errorf("aggregate into %T, requires a pointer", item.into)
+		}
+		if groups == 0 {
+			continue
+		}
+		if rv.Elem().Kind() != reflect.Map {
+			return fmt.Errorf("aggregate the groups into %T, requires a pointer of map", item.into)
+		}
+		key := rv.Elem().Type().Key()
+		if groups > 1 && (key.Kind() != reflect.Struct || key.NumField() != groups) {
+			return fmt.Errorf("aggregate %d group columns into the map keyed by %s, requires a struct of %d fields", groups, key, groups)
+		}
+		if !m.Grp.Rollup {
+			continue
+		}
+		keys := []reflect.Type{key}
+		if groups > 1 {
+			keys = keys[:0]
+			for i := 0; i < key.NumField(); i++ {
+				keys = append(keys, key.Field(i).Type)
+			}
+		}
+		for _, k := range keys {
+			if !reflect.PtrTo(k).Implements(scannerType) {
+				return fmt.Errorf("aggregate the rollup into the map keyed by %s, requires the group columns of sql.Scanner such as sql.NullString for the NULL of the super aggregate rows", key)
+			}
+		}
+	}
+	return nil
+}
+
+var scannerType = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+
+// Aggregate compute the aggregates added by Model.Into over the records matched by opts. without
+// a group each aggregate is scanned into a pointer of the scalar, with a group it's scanned into a
+// pointer of the map keyed by the group, the key is a struct of the group columns in order where
+// there are more than one. the super aggregate rows of Rollup have NULL group columns, so they
+// are sql.Scanner such as sql.NullString then
+// usage:
+//
+//	var total float64
+//	var byAuthor map[string]int64
+//	var byAuthorStatus map[struct {
+//		AuthorID string
+//		Status   string
+//	}]int64
+//	err := repo.Aggregate(ctx, GetModel(nil, &Book{}).Into(Coalesce(SUM("price"), Value(0)), &total))
+//	err = repo.Aggregate(ctx, GetModel(nil, &Book{}).GroupBy("author_id").Into(Count("id"), &byAuthor))
+//	err = repo.Aggregate(ctx, GetModel(nil, &Book{}).GroupBy("author_id", "status").Into(Count("id"), &byAuthorStatus))
+func (db *dbrepo) Aggregate(ctx context.Context, model *Model, opts ...MatchOption) error {
+	ctx, cancel := db.options.context(ctx)
+	defer cancel()
+	return db.observe(ctx, "Aggregate", opts, func(ctx context.Context) (int64, error) {
+		return db.aggregate(ctx, model, opts)
+	})
+}
+
+// aggregate scan the aggregates, the rows are the groups scanned
+func (db *dbrepo) aggregate(ctx context.Context, model *Model, opts []MatchOption) (int64, error) {
+	if err := model.checkAggregates(); err != nil {
+		return 0, err
+	}
+	m := *model
+	m.Result = nil
+	selector, _ := db.prepare(ctx, &m)
+	db.applyOptions(selector, opts...)
+	schema := db.schema()
+	groups := m.groups()
+	var columns []string
+	var values []any
+	for i, group := range groups {
+		var str string
+		var vs []any
+		if raw, ok := group.(rawColumn); ok {
+			str = string(raw)
+		} else {
+			var err error
+			if str, vs, err = db.compileExpression(schema, group, schema.Field); err != nil {
+				return 0, err
+			}
+		}
+		columns, values = append(columns, fmt.Sprintf("%s AS agg_group_%d", str, i)), append(values, vs...)
+	}
+	for i, item := range m.aggregates {
+		str, vs, err := db.compileFunc(schema, item.fn)
+		if err != nil {
+			return 0, err
+		}
+		columns, values = append(columns, fmt.Sprintf("%s AS agg_%d", str, i)), append(values, vs...)
+	}
+	rows, err := selector.Select(strings.Join(columns, ","), values...).Rows()
+	if err != nil {
+		return 0, db.transformError(ctx, err)
+	}
+	defer rows.Close()
+	var maps []reflect.Value
+	if len(groups) > 0 {
+		for _, item := range m.aggregates {
+			mv := reflect.ValueOf(item.into).Elem()
+			mv.Set(reflect.MakeMap(mv.Type()))
+			maps = append(maps, mv)
+		}
+	}
+	var scanned int64
+	for rows.Next() {
+		var dests []any
+		var key reflect.Value
+		switch {
+		case len(groups) == 1:
+			key = reflect.New(maps[0].Type().Key())
+			dests = append(dests, key.Interface())
+		case len(groups) > 1:
+			key = reflect.New(maps[0].Type().Key())
+			for i := 0; i < len(groups); i++ {
+				dests = append(dests, key.Elem().Field(i).Addr().Interface())
+			}
+		}
+		for i, item := range m.aggregates {
+			if len(groups) > 0 {
+				dests = append(dests, reflect.New(maps[i].Type().Elem()).Interface())
+			} else {
+				dests = append(dests, item.into)
+			}
+		}
+		if err := rows.Scan(dests...); err != nil {
+			return scanned, db.transformError(ctx, err)
+		}
+		scanned++
+		for i, mv := range maps {
+			k := key.Elem()
+			if !k.Type().AssignableTo(mv.Type().Key()) {
+				if !k.Type().ConvertibleTo(mv.Type().Key()) {
+					return scanned, fmt.Errorf("aggregate the groups of %s into the map keyed by %s", k.Type(), mv.Type().Key())
+				}
+				k = k.Convert(mv.Type().Key())
+			}
+			mv.SetMapIndex(k, reflect.ValueOf(dests[len(groups)+i]).Elem())
+		}
+	}
+	return scanned, db.transformError(ctx, rows.Err())
+}
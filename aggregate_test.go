@@ -0,0 +1,168 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+type Sale struct {
+	ID     uint
+	Region string
+	Amount int
+	Status string
+}
+
+func TestAggregate(t *testing.T) {
+	repo := New(sqliteDB(t, &Sale{}), &Sale{})
+	ctx := context.Background()
+	assert.Nil(t, repo.Create(ctx, []*Sale{
+		{Region: "east", Amount: 10, Status: "paid"},
+		{Region: "east", Amount: 20, Status: "open"},
+		{Region: "west", Amount: 5, Status: "paid"},
+	}))
+	var total, paid int64
+	var avg float64
+	var count int
+	err := repo.Aggregate(ctx, GetModel(nil, &Sale{}).
+		Into(SUM("amount"), &total).
+		Into(AVG("amount"), &avg).
+		Into(Count("id"), &count).
+		Into(SUM(Case().When(Field("amount"), func(opts *MatchOptions, schema Schema) {
+			opts.EQ(schema.Field("status"), "paid")
+		}).Else(0)), &paid))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(35), total)
+	assert.InDelta(t, 35.0/3, avg, 0.0001)
+	assert.Equal(t, 3, count)
+	assert.Equal(t, int64(15), paid)
+
+	var sums map[string]int64
+	var statuses map[string]string
+	err = repo.Aggregate(ctx, GetModel(nil, &Sale{}).GroupBy("region").
+		Into(SUM("amount"), &sums).
+		Into(GroupConcat("status", "|"), &statuses))
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]int64{"east": 30, "west": 5}, sums)
+	assert.ElementsMatch(t, []string{"paid", "open"}, strings.Split(statuses["east"], "|"))
+	assert.Equal(t, "paid", statuses["west"])
+
+	var counts map[string]int64
+	err = repo.Aggregate(ctx, GetModel(nil, &Sale{}).GroupBy("region").Having(func(opts *MatchOptions, schema Schema) {
+		opts.GT("COUNT(id)", 1)
+	}).Into(Count("id"), &counts))
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]int64{"east": 2}, counts)
+
+	var none sql.NullInt64
+	var zero int64
+	err = repo.Aggregate(ctx, GetModel(nil, &Sale{}).Into(SUM("amount"), &none).Into(Coalesce(SUM("amount"), Value(0)), &zero),
+		func(opts *MatchOptions, schema Schema) { opts.EQ(schema.Field("region"), "north") })
+	assert.Nil(t, err)
+	assert.False(t, none.Valid)
+	assert.Equal(t, int64(0), zero)
+
+	type regionStatus struct {
+		Region string
+		Status string
+	}
+	var byStatus map[regionStatus]int64
+	err = repo.Aggregate(ctx, GetModel(nil, &Sale{}).GroupBy("region", "status").Into(SUM("amount"), &byStatus))
+	assert.Nil(t, err)
+	assert.Equal(t, map[regionStatus]int64{{"east", "paid"}: 10, {"east", "open"}: 20, {"west", "paid"}: 5}, byStatus)
+
+	err = repo.Aggregate(ctx, GetModel(nil, &Sale{}).GroupBy("region", "status").Into(SUM("amount"), &sums))
+	assert.ErrorContains(t, err, "requires a struct of 2 fields")
+	err = repo.Aggregate(ctx, GetModel(nil, &Sale{}).GroupBy("region").Into(SUM("amount"), &total))
+	assert.ErrorContains(t, err, "requires a pointer of map")
+	err = repo.Aggregate(ctx, GetModel(nil, &Sale{}))
+	assert.ErrorContains(t, err, "requires at least one aggregate")
+	err = repo.Aggregate(ctx, GetModel(nil, &Sale{}).Into(SUM(Case().When(Field("amount")).Else(0)), &total))
+	assert.EqualError(t, err, "CASE WHEN requires at least one condition")
+	err = repo.Aggregate(ctx, GetModel(nil, &Sale{}).GroupBy("region").Rollup().Into(SUM("amount"), &sums))
+	assert.ErrorContains(t, err, "requires the group columns of sql.Scanner")
+}
+
+func TestGormRepository_Aggregate(t *testing.T) {
+	db, mock, err := sqlmock.New() // mock sql.DB
+	assert.Nil(t, err)
+	defer db.Close()
+	defer assert.Nil(t, mock.ExpectationsWereMet())
+	gdb, err := gorm.Open(dialector(db)) // open gorm db
+	assert.Nil(t, err)
+	repo := New(gdb, &Book{})
+	func() {
+		execSql := "^SELECT `books`.`author_id` AS agg_group_0,GROUP_CONCAT\\(name SEPARATOR ', '\\) AS agg_0,SUM\\(CASE WHEN `books`.`name` LIKE \\? THEN \\? ELSE \\? END\\) AS agg_1 FROM `books` WHERE `books`.`id` > \\? GROUP BY `books`.`author_id`$"
+		mock.ExpectQuery(execSql).
+			WithArgs("a%", 1, 0, "1").
+			WillReturnRows(sqlmock.NewRows([]string{"agg_group_0", "agg_0", "agg_1"}).AddRow("1", "a, b", 2))
+	}()
+	var names map[string]string
+	var counts map[string]int
+	err = repo.Aggregate(context.Background(), GetModel(nil, &Book{}).GroupBy("author_id").
+		Into(GroupConcat("name", ", "), &names).
+		Into(SUM(Case().When(1, func(opts *MatchOptions, schema Schema) { opts.LIKE(schema.Field("name"), "a%") }).Else(0)), &counts),
+		func(opts *MatchOptions, schema Schema) { opts.GT(schema.Field("id"), "1") })
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"1": "a, b"}, names)
+	assert.Equal(t, map[string]int{"1": 2}, counts)
+}
+
+func TestGormRepository_AggregateRollup(t *testing.T) {
+	db, mock, err := sqlmock.New() // mock sql.DB
+	assert.Nil(t, err)
+	defer db.Close()
+	defer assert.Nil(t, mock.ExpectationsWereMet())
+	gdb, err := gorm.Open(dialector(db)) // open gorm db
+	assert.Nil(t, err)
+	repo := New(gdb, &Sale{})
+	func() {
+		execSql := "^SELECT `sales`.`region` AS agg_group_0,`sales`.`status` AS agg_group_1,SUM\\(amount\\) AS agg_0 FROM `sales` GROUP BY `sales`.`region`,`sales`.`status` WITH ROLLUP$"
+		mock.ExpectQuery(execSql).
+			WillReturnRows(sqlmock.NewRows([]string{"agg_group_0", "agg_group_1", "agg_0"}).
+				AddRow("east", "paid", 10).AddRow("east", nil, 10).AddRow(nil, nil, 10))
+	}()
+	type regionStatus struct {
+		Region sql.NullString
+		Status sql.NullString
+	}
+	var sums map[regionStatus]int64
+	err = repo.Aggregate(context.Background(), GetModel(nil, &Sale{}).GroupBy("region", "status").Rollup().Into(SUM("amount"), &sums))
+	assert.Nil(t, err)
+	east := sql.NullString{String: "east", Valid: true}
+	assert.Equal(t, map[regionStatus]int64{
+		{east, sql.NullString{String: "paid", Valid: true}}: 10,
+		{east, sql.NullString{}}:                            10,
+		{}:                                                  10,
+	}, sums)
+}
+
+func TestFunc_String(t *testing.T) {
+	db, _, err := sqlmock.New() // mock sql.DB
+	assert.Nil(t, err)
+	defer db.Close()
+	gdb, err := gorm.Open(dialector(db)) // open gorm db
+	assert.Nil(t, err)
+	schema := &DBSchema{DB: gdb, Table: &Sale{}}
+	assert.Equal(t, "COALESCE(`status`, 'x')", Coalesce(Field("status"), Value("x")).String(schema))
+	assert.Equal(t, "GROUP_CONCAT(`region` SEPARATOR ',')", GroupConcat(Field("region"), ",").String(schema))
+	paid := SUM(Case().When(Field("amount"), func(opts *MatchOptions, schema Schema) {
+		opts.EQ(schema.Field("status"), "paid")
+	}).Else(0))
+	assert.Equal(t, "SUM(CASE WHEN `sales`.`status` = 'paid' THEN `amount` ELSE 0 END)", paid.String(schema))
+	total := Field(SUM("amount")).AS("total")
+	assert.Equal(t, "SUM(amount) AS total", total.String(schema))
+	assert.Equal(t, "COALESCE(title, 'x')", Coalesce("title", Value("x")).String(plainSchema{}))
+	assert.Equal(t, "%!(ERROR=CASE WHEN requires at least one condition)", Case().When(1).End().String(schema))
+}
+
+func TestMemoryRepository_Aggregate(t *testing.T) {
+	var total int64
+	err := NewMemory(&Sale{}).Aggregate(context.Background(), GetModel(nil, &Sale{}).Into(SUM("amount"), &total))
+	assert.ErrorIs(t, err, ErrUnsupported)
+}
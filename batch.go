@@ -0,0 +1,131 @@
+// Copyright (c) 2024 Yang,Zhong
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+type BatchOption func(opts *batchOptions)
+
+type batchOptions struct {
+	tx bool
+}
+
+// InTransaction create all the chunks in one transaction, the first failed chunk rolls back the
+// created ones and the chunks after it are not tried, so the BatchError reports it alone with
+// Created 0
+func InTransaction() BatchOption {
+	return func(opts *batchOptions) {
+		opts.tx = true
+	}
+}
+
+// ChunkFailure describe a chunk which isn't created, the records of it are v[Offset:Offset+Size]
+type ChunkFailure struct {
+	Index  int
+	Offset int
+	Size   int
+	Err    error
+}
+
+// BatchError is returned by CreateInBatches when some of the chunks fail, the failed chunks
+// don't stop the others without InTransaction, the chunks after a canceled context fail with ErrCanceled
+type BatchError struct {
+	// Created is the number of the records created
+	Created int
+	// Failures list every failed chunk in order
+	Failures []ChunkFailure
+}
+
+func (e *BatchError) Error() string {
+	f := e.Failures[0]
+	return fmt.Sprintf("%d of the chunks failed, the first is chunk %d [%d:%d]: %s", len(e.Failures), f.Index, f.Offset, f.Offset+f.Size, f.Err.Error())
+}
+
+// Unwrap return the error of the first failed chunk
+func (e *BatchError) Unwrap() error {
+	return e.Failures[0].Err
+}
+
+// createInBatches split the slice v into chunks of size and create them one by one, the first
+// failed chunk stops the others if atomic, which are rolled back by the caller then
+func createInBatches(ctx context.Context, v any, size int, atomic bool, create func(ctx context.Context, chunk any) error) error {
+	if size <= 0 {
+		return fmt.Errorf("create in batches requires a positive size, got %d", size)
+	}
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() != reflect.Slice {
+		return fmt.Errorf("create in batches only support slice as value, got %T", v)
+	}
+	var berr BatchError
+	for i, offset := 0, 0; offset < rv.Len(); i, offset = i+1, offset+size {
+		end := offset + size
+		if end > rv.Len() {
+			end = rv.Len()
+		}
+		err := contextError(ctx)
+		if err == nil {
+			err = create(ctx, rv.Slice(offset, end).Interface())
+		}
+		if err != nil {
+			failure := ChunkFailure{Index: i, Offset: offset, Size: end - offset, Err: err}
+			if atomic {
+				return &BatchError{Failures: []ChunkFailure{failure}}
+			}
+			berr.Failures = append(berr.Failures, failure)
+			continue
+		}
+		berr.Created += end - offset
+	}
+	if len(berr.Failures) > 0 {
+		return &berr
+	}
+	return nil
+}
+
+// CreateInBatches create the records of the slice v in chunks of size, a *BatchError
+// describes the chunks which fail
+// usage:
+//
+//	err := repo.CreateInBatches(ctx, users, 1000)
+//	var berr *BatchError
+//	if errors.As(err, &berr) {
+//		for _, f := range berr.Failures {
+//			retry(users[f.Offset : f.Offset+f.Size])
+//		}
+//	}
+//	// all or nothing
+//	err = repo.CreateInBatches(ctx, users, 1000, InTransaction())
+func (db *dbrepo) CreateInBatches(ctx context.Context, v any, size int, opts ...BatchOption) error {
+	var o batchOptions
+	for _, apply := range opts {
+		apply(&o)
+	}
+	return db.observe(ctx, "CreateInBatches", nil, func(ctx context.Context) (int64, error) {
+		create := func(ctx context.Context, chunk any) error {
+			return db.Create(ctx, chunk)
+		}
+		var err error
+		if o.tx {
+			err = db.WithTx(ctx, func(ctx context.Context) error {
+				return createInBatches(ctx, v, size, true, create)
+			})
+		} else {
+			err = createInBatches(ctx, v, size, false, create)
+		}
+		var berr *BatchError
+		if errors.As(err, &berr) {
+			return int64(berr.Created), err
+		}
+		if err != nil {
+			return 0, err
+		}
+		return int64(upsertLen(v)), nil
+	})
+}
@@ -0,0 +1,65 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testCreateInBatches(t *testing.T, repo RDBRepository, opts ...BatchOption) {
+	ctx := context.Background()
+	members := []Member{{ID: 1}, {ID: 2}, {ID: 3}, {ID: 1}, {ID: 5}, {ID: 6}, {ID: 7}}
+	err := repo.CreateInBatches(ctx, members, 2, opts...)
+	var berr *BatchError
+	assert.True(t, errors.As(err, &berr))
+	assert.True(t, errors.Is(err, ErrDuplicatedKey))
+	assert.Equal(t, 5, berr.Created)
+	assert.Equal(t, 1, len(berr.Failures))
+	assert.Equal(t, ChunkFailure{Index: 1, Offset: 2, Size: 2, Err: ErrDuplicatedKey}, berr.Failures[0])
+	var count int64
+	assert.Nil(t, repo.Count(ctx, &count))
+	assert.Equal(t, int64(5), count)
+
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	err = repo.CreateInBatches(cctx, []*Member{{}, {}, {}}, 2, opts...)
+	assert.True(t, errors.As(err, &berr))
+	assert.True(t, errors.Is(err, ErrCanceled))
+	assert.Equal(t, 2, len(berr.Failures))
+	assert.Equal(t, 1, berr.Failures[1].Size)
+
+	inserted := []*Member{{Name: "a"}, {Name: "b"}, {Name: "c"}}
+	assert.Nil(t, repo.CreateInBatches(ctx, inserted, 2, opts...))
+	assert.Equal(t, []uint{8, 9, 10}, []uint{inserted[0].ID, inserted[1].ID, inserted[2].ID})
+	assert.NotNil(t, repo.CreateInBatches(ctx, inserted, 0))
+}
+
+func testCreateInBatchesInTransaction(t *testing.T, repo RDBRepository) {
+	ctx := context.Background()
+	err := repo.CreateInBatches(ctx, []Member{{ID: 1}, {ID: 2}, {ID: 3}, {ID: 1}, {ID: 5}}, 2, InTransaction())
+	var berr *BatchError
+	assert.True(t, errors.As(err, &berr))
+	assert.True(t, errors.Is(err, ErrDuplicatedKey))
+	assert.Equal(t, 0, berr.Created)
+	assert.Equal(t, []ChunkFailure{{Index: 1, Offset: 2, Size: 2, Err: ErrDuplicatedKey}}, berr.Failures)
+	var count int64
+	assert.Nil(t, repo.Count(ctx, &count))
+	assert.Equal(t, int64(0), count)
+
+	inserted := []*Member{{Name: "a"}, {Name: "b"}, {Name: "c"}}
+	assert.Nil(t, repo.CreateInBatches(ctx, inserted, 2, InTransaction()))
+	assert.Nil(t, repo.Count(ctx, &count))
+	assert.Equal(t, int64(3), count)
+}
+
+func TestCreateInBatches(t *testing.T) {
+	testCreateInBatches(t, New(sqliteDB(t, &Member{}), &Member{}))
+	testCreateInBatchesInTransaction(t, New(sqliteDB(t, &Member{}), &Member{}))
+}
+
+func TestMemoryRepository_CreateInBatches(t *testing.T) {
+	testCreateInBatches(t, NewMemory(&Member{}))
+	testCreateInBatchesInTransaction(t, NewMemory(&Member{}))
+}
@@ -0,0 +1,221 @@
+// Copyright (c) 2024 Yang,Zhong
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+package repository
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type CacheStore interface {
+	// Get the value of key, ok is false when the key is missed or expired
+	Get(ctx context.Context, key string) (value []byte, ok bool)
+	// Set the value of key, ttl <= 0 means the value never expires
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration)
+	// Delete the key
+	Delete(ctx context.Context, key string)
+}
+
+type cachedRepo struct {
+	repo  Repository
+	store CacheStore
+	ttl   time.Duration
+}
+
+var _ Repository = &cachedRepo{}
+
+// Cached cache the results of First, Find and Count in store, the key is built from the
+// model name and MatchOptions.Sum. the entries of a model are invalidated by any write
+// through the cached repository, the writes in a transaction invalidate them again after it
+// commits. queries with *Model and queries in a transaction are not cached.
+// usage:
+//
+//	repo := Cached(New(db, &User{}), NewLRUStore(1024), time.Minute)
+//	err := repo.Find(ctx, &users, Role("member"))
+func Cached(repo Repository, store CacheStore, ttl time.Duration) Repository {
+	return &cachedRepo{repo: repo, store: store, ttl: ttl}
+}
+
+func (c *cachedRepo) First(ctx context.Context, v any, opts ...MatchOption) error {
+	return c.cache(ctx, "first", v, opts, c.repo.First)
+}
+
+func (c *cachedRepo) Find(ctx context.Context, v any, opts ...MatchOption) error {
+	return c.cache(ctx, "find", v, opts, c.repo.Find)
+}
+
+func (c *cachedRepo) Count(ctx context.Context, v any, opts ...MatchOption) error {
+	return c.cache(ctx, "count", v, opts, c.repo.Count)
+}
+
+func (c *cachedRepo) Update(ctx context.Context, v any) error {
+	defer c.invalidate(ctx)
+	return c.repo.Update(ctx, v)
+}
+
+func (c *cachedRepo) Delete(ctx context.Context, opts ...MatchOption) error {
+	defer c.invalidate(ctx)
+	return c.repo.Delete(ctx, opts...)
+}
+
+func (c *cachedRepo) Create(ctx context.Context, v any) error {
+	defer c.invalidate(ctx)
+	return c.repo.Create(ctx, v)
+}
+
+func (c *cachedRepo) UpdateFields(ctx context.Context, fields Fields, opts ...MatchOption) error {
+	defer c.invalidate(ctx)
+	return c.repo.UpdateFields(ctx, fields, opts...)
+}
+
+func (c *cachedRepo) cache(ctx context.Context, op string, v any, opts []MatchOption, query func(context.Context, any, ...MatchOption) error) error {
+	if _, ok := v.(*Model); ok {
+		return query(ctx, v, opts...)
+	}
+	if _, ok := TxFromContext(ctx); ok {
+		return query(ctx, v, opts...)
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return query(ctx, v, opts...)
+	}
+	key := c.key(ctx, op, v, opts)
+	if data, ok := c.store.Get(ctx, key); ok {
+		rv.Elem().Set(reflect.Zero(rv.Elem().Type()))
+		if err := gob.NewDecoder(bytes.NewReader(data)).Decode(v); err == nil {
+			return nil
+		}
+	}
+	if err := query(ctx, v, opts...); err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err == nil {
+		c.store.Set(ctx, key, buf.Bytes(), c.ttl)
+	}
+	return nil
+}
+
+// key is prefixed with the current version of the model, so the invalidation only
+// needs to drop the version
+func (c *cachedRepo) key(ctx context.Context, op string, v any, opts []MatchOption) string {
+	model := c.modelName()
+	o := MatchOptions{schema: plainSchema{table: model}}
+	o.Apply(opts...)
+	return fmt.Sprintf("%s:%s:%s:%T:%s", c.versionKey(), c.version(ctx), op, v, o.Sum())
+}
+
+func (c *cachedRepo) version(ctx context.Context) string {
+	if version, ok := c.store.Get(ctx, c.versionKey()); ok {
+		return string(version)
+	}
+	version := strconv.FormatInt(time.Now().UnixNano(), 36)
+	c.store.Set(ctx, c.versionKey(), []byte(version), 0)
+	return version
+}
+
+// invalidate drop the version, and drop it again after the transaction commits, since the
+// readers outside the transaction may cache the rows it hides under the new version
+func (c *cachedRepo) invalidate(ctx context.Context) {
+	c.store.Delete(ctx, c.versionKey())
+	if _, ok := TxFromContext(ctx); ok {
+		AfterCommit(ctx, func(ctx context.Context) error {
+			c.store.Delete(ctx, c.versionKey())
+			return nil
+		})
+	}
+}
+
+func (c *cachedRepo) versionKey() string {
+	return "repository:" + c.modelName()
+}
+
+func (c *cachedRepo) modelName() string {
+	if namer, ok := c.repo.(ModelNamer); ok {
+		if name := namer.ModelName(); name != "" {
+			return name
+		}
+	}
+	return fmt.Sprintf("%p", c.repo)
+}
+
+type lruEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+type lruStore struct {
+	mu       sync.Mutex
+	capacity int
+	entries  *list.List
+	index    map[string]*list.Element
+}
+
+var _ CacheStore = &lruStore{}
+
+// NewLRUStore create an in-process CacheStore which keeps at most capacity entries,
+// the least recently used entry is evicted first
+func NewLRUStore(capacity int) CacheStore {
+	return &lruStore{
+		capacity: capacity,
+		entries:  list.New(),
+		index:    make(map[string]*list.Element),
+	}
+}
+
+func (s *lruStore) Get(ctx context.Context, key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	elem, ok := s.index[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*lruEntry)
+	if !entry.expires.IsZero() && time.Now().After(entry.expires) {
+		s.remove(elem)
+		return nil, false
+	}
+	s.entries.MoveToFront(elem)
+	return entry.value, true
+}
+
+func (s *lruStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry := &lruEntry{key: key, value: value}
+	if ttl > 0 {
+		entry.expires = time.Now().Add(ttl)
+	}
+	if elem, ok := s.index[key]; ok {
+		elem.Value = entry
+		s.entries.MoveToFront(elem)
+		return
+	}
+	s.index[key] = s.entries.PushFront(entry)
+	for s.capacity > 0 && s.entries.Len() > s.capacity {
+		s.remove(s.entries.Back())
+	}
+}
+
+func (s *lruStore) Delete(ctx context.Context, key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if elem, ok := s.index[key]; ok {
+		s.remove(elem)
+	}
+}
+
+func (s *lruStore) remove(elem *list.Element) {
+	s.entries.Remove(elem)
+	delete(s.index, elem.Value.(*lruEntry).key)
+}
@@ -0,0 +1,93 @@
+package repository
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+type countingRepo struct {
+	RDBRepository
+	queries int
+}
+
+func (r *countingRepo) Find(ctx context.Context, v any, opts ...MatchOption) error {
+	r.queries++
+	return r.RDBRepository.Find(ctx, v, opts...)
+}
+
+func (r *countingRepo) Count(ctx context.Context, v any, opts ...MatchOption) error {
+	r.queries++
+	return r.RDBRepository.Count(ctx, v, opts...)
+}
+
+func TestCached(t *testing.T) {
+	ctx := context.Background()
+	backend := &countingRepo{RDBRepository: NewMemory(&Book{})}
+	repo := Cached(backend, NewLRUStore(16), time.Minute)
+	assert.Nil(t, repo.Create(ctx, []Book{{ID: "1", AuthorID: "1"}, {ID: "2", AuthorID: "2"}}))
+	for i := 0; i < 2; i++ {
+		var books []*Book
+		assert.Nil(t, repo.Find(ctx, &books, AuthorID("1")))
+		assert.Equal(t, []*Book{{ID: "1", AuthorID: "1"}}, books)
+	}
+	assert.Equal(t, 1, backend.queries)
+	var count int64
+	assert.Nil(t, repo.Count(ctx, &count, AuthorID([]string{"1", "2"})))
+	assert.Nil(t, repo.Count(ctx, &count, AuthorID([]string{"1", "2"})))
+	assert.Equal(t, int64(2), count)
+	assert.Equal(t, 2, backend.queries)
+	assert.Nil(t, repo.UpdateFields(ctx, Fields{"name": "hello"}, AuthorID("1")))
+	var books []*Book
+	assert.Nil(t, repo.Find(ctx, &books, AuthorID("1")))
+	assert.Equal(t, []*Book{{ID: "1", Name: "hello", AuthorID: "1"}}, books)
+	assert.Equal(t, 3, backend.queries)
+}
+
+func TestLRUStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewLRUStore(2)
+	store.Set(ctx, "a", []byte("a"), 0)
+	store.Set(ctx, "b", []byte("b"), 0)
+	_, ok := store.Get(ctx, "a")
+	assert.True(t, ok)
+	store.Set(ctx, "c", []byte("c"), 0)
+	_, ok = store.Get(ctx, "b")
+	assert.False(t, ok)
+	store.Set(ctx, "d", []byte("d"), time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	_, ok = store.Get(ctx, "d")
+	assert.False(t, ok)
+}
+
+func TestCached_Transaction(t *testing.T) {
+	ctx := context.Background()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "cache.db")+"?_journal_mode=WAL&_busy_timeout=5000"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&Member{}); err != nil {
+		t.Fatal(err)
+	}
+	repo := Cached(New(db, &Member{}), NewLRUStore(16), time.Minute)
+	assert.Nil(t, repo.Create(ctx, &Member{Name: "old"}))
+	err = NewTransactor(db).WithTx(ctx, func(txCtx context.Context) error {
+		if err := repo.UpdateFields(txCtx, Fields{"name": "new"}, func(opts *MatchOptions, schema Schema) { opts.EQ(schema.Field("name"), "old") }); err != nil {
+			return err
+		}
+		// the reader outside the transaction caches the old row
+		var members []Member
+		assert.Nil(t, repo.Find(ctx, &members))
+		assert.Equal(t, "old", members[0].Name)
+		return nil
+	})
+	assert.Nil(t, err)
+	var members []Member
+	assert.Nil(t, repo.Find(ctx, &members))
+	assert.Equal(t, "new", members[0].Name)
+}
@@ -0,0 +1,314 @@
+// Copyright (c) 2024 Yang,Zhong
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+package repository
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	gschema "gorm.io/gorm/schema"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursor page the records by the values of the sort keys of the last record
+type Cursor struct {
+	Token string
+	Sort  []string
+}
+
+type CursorPage struct {
+	// Next is the token of the next page, empty if there is no next page
+	Next string
+	// Prev is the token of the previous page, empty if there is no previous page
+	Prev string
+}
+
+type cursorKey struct {
+	field string
+	desc  bool
+}
+
+type cursorValue struct {
+	Kind  string `json:"k"`
+	Value string `json:"v"`
+}
+
+type cursorToken struct {
+	Values []cursorValue `json:"v"`
+	Before bool          `json:"b,omitempty"`
+}
+
+var cursorSchemas sync.Map
+
+func (c Cursor) keys() []cursorKey {
+	var keys []cursorKey
+	for _, s := range c.Sort {
+		for _, item := range strings.Split(s, ",") {
+			parts := strings.Fields(item)
+			if len(parts) == 0 {
+				continue
+			}
+			key := cursorKey{field: parts[0]}
+			if len(parts) > 1 {
+				key.desc = strings.EqualFold(parts[len(parts)-1], "DESC")
+			}
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// compile the cursor into the keyset condition and the order, the fields are quoted through the
+// schema. NULL is the smallest value of a sort key, as mysql, sqlite and sqlserver order it, the
+// order of postgres is told so with NULLS FIRST and NULLS LAST
+func (c Cursor) compile(schema Schema, dialect string) (cond string, values []any, sorts []string, err error) {
+	keys := c.keys()
+	if len(keys) == 0 {
+		return "", nil, nil, fmt.Errorf("%w: cursor requires sort keys", ErrInvalidCursor)
+	}
+	token, err := decodeCursor(c.Token)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	fields := make([]string, len(keys))
+	ascending := true
+	for i, key := range keys {
+		fields[i] = schema.Quote(key.field)
+		asc := key.desc == token.Before
+		dir := "ASC"
+		if !asc {
+			dir = "DESC"
+		}
+		if dialect == "postgres" {
+			if asc {
+				dir += " NULLS FIRST"
+			} else {
+				dir += " NULLS LAST"
+			}
+		}
+		sorts = append(sorts, fields[i]+" "+dir)
+		ascending = ascending && asc
+	}
+	if token.Values == nil {
+		return "", nil, sorts, nil
+	}
+	if values, err = token.values(len(keys)); err != nil {
+		return "", nil, nil, err
+	}
+	nulls := false
+	for _, v := range values {
+		nulls = nulls || v == nil
+	}
+	// the rows with NULL are before any value of the ascending keys, so the row comparison
+	// skips them correctly
+	if ascending && !nulls {
+		if len(keys) == 1 {
+			return fmt.Sprintf("%s > ?", fields[0]), values, sorts, nil
+		}
+		holders := strings.TrimSuffix(strings.Repeat("?, ", len(keys)), ", ")
+		return fmt.Sprintf("(%s) > (%s)", strings.Join(fields, ", "), holders), values, sorts, nil
+	}
+	// expand to (a > ?) OR (a = ? AND b < ?) ..., the comparisons with NULL are written with IS NULL
+	var ors []string
+	var args []any
+	for i, key := range keys {
+		var ands []string
+		var ins []any
+		for j := 0; j < i; j++ {
+			if values[j] == nil {
+				ands = append(ands, fields[j]+" IS NULL")
+				continue
+			}
+			ands = append(ands, fields[j]+" = ?")
+			ins = append(ins, values[j])
+		}
+		switch asc := key.desc == token.Before; {
+		case asc && values[i] == nil:
+			ands = append(ands, fields[i]+" IS NOT NULL")
+		case asc:
+			ands = append(ands, fields[i]+" > ?")
+			ins = append(ins, values[i])
+		case values[i] == nil:
+			// nothing is after NULL in the descending order
+			continue
+		default:
+			ands = append(ands, fmt.Sprintf("(%s < ? OR %s IS NULL)", fields[i], fields[i]))
+			ins = append(ins, values[i])
+		}
+		ors = append(ors, "("+strings.Join(ands, " AND ")+")")
+		args = append(args, ins...)
+	}
+	if len(ors) == 0 {
+		return "1 <> 1", nil, sorts, nil
+	}
+	return "(" + strings.Join(ors, " OR ") + ")", args, sorts, nil
+}
+
+func (token cursorToken) values(n int) ([]any, error) {
+	if len(token.Values) != n {
+		return nil, fmt.Errorf("%w: %d values for %d sort keys", ErrInvalidCursor, len(token.Values), n)
+	}
+	values := make([]any, n)
+	for i, v := range token.Values {
+		var err error
+		switch v.Kind {
+		case "n":
+			values[i] = nil
+		case "s":
+			values[i] = v.Value
+		case "i":
+			values[i], err = strconv.ParseInt(v.Value, 10, 64)
+		case "f":
+			values[i], err = strconv.ParseFloat(v.Value, 64)
+		case "b":
+			values[i], err = strconv.ParseBool(v.Value)
+		case "t":
+			values[i], err = time.Parse(time.RFC3339Nano, v.Value)
+		default:
+			err = fmt.Errorf("unknown kind %s", v.Kind)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidCursor, err.Error())
+		}
+	}
+	return values, nil
+}
+
+func decodeCursor(token string) (cursorToken, error) {
+	var ct cursorToken
+	if token == "" {
+		return ct, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return ct, fmt.Errorf("%w: %s", ErrInvalidCursor, err.Error())
+	}
+	if err := json.Unmarshal(data, &ct); err != nil {
+		return ct, fmt.Errorf("%w: %s", ErrInvalidCursor, err.Error())
+	}
+	if ct.Values == nil {
+		ct.Values = []cursorValue{}
+	}
+	return ct, nil
+}
+
+func encodeCursor(values []any, before bool) (string, error) {
+	token := cursorToken{Before: before}
+	for _, v := range values {
+		var cv cursorValue
+		switch vl := memValue(v).(type) {
+		case nil:
+			cv.Kind = "n"
+		case string:
+			cv = cursorValue{Kind: "s", Value: vl}
+		case int64:
+			cv = cursorValue{Kind: "i", Value: strconv.FormatInt(vl, 10)}
+		case float64:
+			cv = cursorValue{Kind: "f", Value: strconv.FormatFloat(vl, 'g', -1, 64)}
+		case bool:
+			cv = cursorValue{Kind: "b", Value: strconv.FormatBool(vl)}
+		case time.Time:
+			cv = cursorValue{Kind: "t", Value: vl.Format(time.RFC3339Nano)}
+		default:
+			return "", fmt.Errorf("%w: can't encode %T", ErrInvalidCursor, v)
+		}
+		token.Values = append(token.Values, cv)
+	}
+	data, err := json.Marshal(token)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// findPage fetch one more record than the limit to know whether there are more records,
+// the records fetched backward are reversed to keep the order of the sort
+func findPage(ctx context.Context, find func(context.Context, any, ...MatchOption) error, namer gschema.Namer, schema Schema, v any, opts []MatchOption) (CursorPage, error) {
+	var page CursorPage
+	o := MatchOptions{schema: schema}
+	o.Apply(opts...)
+	if o.Cursor == nil {
+		return page, fmt.Errorf("%w: FindPage requires a cursor, see MatchOptions.SetCursor", ErrInvalidCursor)
+	}
+	if o.Limit == nil || *o.Limit <= 0 {
+		return page, fmt.Errorf("%w: FindPage requires a positive limit", ErrInvalidCursor)
+	}
+	token, err := decodeCursor(o.Cursor.Token)
+	if err != nil {
+		return page, err
+	}
+	limit := *o.Limit
+	query := append(append([]MatchOption{}, opts...), func(opts *MatchOptions, schema Schema) {
+		opts.SetLimit(limit + 1)
+	})
+	if err := find(ctx, v, query...); err != nil {
+		return page, err
+	}
+	result := v
+	if m, ok := v.(*Model); ok {
+		result = m.Result
+	}
+	rv := reflect.ValueOf(result)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Slice {
+		return page, fmt.Errorf("FindPage only support pointer of slice as result, got %T", result)
+	}
+	rv = rv.Elem()
+	more := rv.Len() > limit
+	if more {
+		rv.Set(rv.Slice(0, limit))
+	}
+	n := rv.Len()
+	if token.Before {
+		swap := reflect.Swapper(rv.Interface())
+		for i, j := 0, n-1; i < j; i, j = i+1, j-1 {
+			swap(i, j)
+		}
+	}
+	if n == 0 {
+		return page, nil
+	}
+	keys := o.Cursor.keys()
+	if (!token.Before && more) || (token.Before && token.Values != nil) {
+		if page.Next, err = rowCursor(rv.Index(n-1), keys, namer, false); err != nil {
+			return page, err
+		}
+	}
+	if (token.Before && more) || (!token.Before && token.Values != nil) {
+		if page.Prev, err = rowCursor(rv.Index(0), keys, namer, true); err != nil {
+			return page, err
+		}
+	}
+	return page, nil
+}
+
+func rowCursor(row reflect.Value, keys []cursorKey, namer gschema.Namer, before bool) (string, error) {
+	row = reflect.Indirect(row)
+	s, err := gschema.Parse(reflect.New(row.Type()).Interface(), &cursorSchemas, namer)
+	if err != nil {
+		return "", err
+	}
+	values := make([]any, len(keys))
+	for i, key := range keys {
+		column := strings.NewReplacer("`", "", `"`, "").Replace(key.field)
+		if i := strings.LastIndex(column, "."); i >= 0 {
+			column = column[i+1:]
+		}
+		f := s.LookUpField(column)
+		if f == nil {
+			return "", fmt.Errorf("%w: sort key %s is not in the result", ErrInvalidCursor, key.field)
+		}
+		values[i], _ = f.ValueOf(context.Background(), row)
+	}
+	return encodeCursor(values, before)
+}
@@ -0,0 +1,136 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func ids(members []Member) []uint {
+	var ret []uint
+	for _, m := range members {
+		ret = append(ret, m.ID)
+	}
+	return ret
+}
+
+func testFindPage(t *testing.T, repo RDBRepository) {
+	ctx := context.Background()
+	assert.Nil(t, repo.Create(ctx, []*Member{{Age: 30}, {Age: 20}, {Age: 20}, {Age: 10}, {Age: 40}}))
+	page := func(token string, sort ...string) ([]Member, CursorPage) {
+		var members []Member
+		p, err := repo.FindPage(ctx, &members, func(opts *MatchOptions, schema Schema) {
+			opts.GT(schema.Field("age"), 10).SetCursor(token, sort...).SetLimit(2)
+		})
+		assert.Nil(t, err)
+		return members, p
+	}
+	for _, sort := range [][]string{{"age DESC", "id"}, {"age DESC", "id DESC"}} {
+		expected := [][]uint{{5, 1}, {2, 3}}
+		if sort[1] == "id DESC" {
+			expected = [][]uint{{5, 1}, {3, 2}}
+		}
+		first, p := page("", sort...)
+		assert.Equal(t, expected[0], ids(first))
+		assert.Empty(t, p.Prev)
+		second, p := page(p.Next, sort...)
+		assert.Equal(t, expected[1], ids(second))
+		assert.Empty(t, p.Next)
+		back, p := page(p.Prev, sort...)
+		assert.Equal(t, expected[0], ids(back))
+		assert.Empty(t, p.Prev)
+		assert.NotEmpty(t, p.Next)
+	}
+	var members []Member
+	_, err := repo.FindPage(ctx, &members, func(opts *MatchOptions, schema Schema) {
+		opts.SetCursor("garbage", "id").SetLimit(2)
+	})
+	assert.True(t, errors.Is(err, ErrInvalidCursor))
+}
+
+func TestFindPage(t *testing.T) {
+	testFindPage(t, New(sqliteDB(t, &Member{}), &Member{}))
+}
+
+func TestMemoryRepository_FindPage(t *testing.T) {
+	testFindPage(t, NewMemory(&Member{}))
+}
+
+func TestCursor_compile(t *testing.T) {
+	token, err := encodeCursor([]any{20, uint(3)}, false)
+	assert.Nil(t, err)
+	cond, values, sorts, err := Cursor{Token: token, Sort: []string{"age", "id"}}.compile(plainSchema{}, "")
+	assert.Nil(t, err)
+	assert.Equal(t, "(age, id) > (?, ?)", cond)
+	assert.Equal(t, []any{int64(20), int64(3)}, values)
+	assert.Equal(t, []string{"age ASC", "id ASC"}, sorts)
+	token, err = encodeCursor([]any{20, uint(3)}, true)
+	assert.Nil(t, err)
+	cond, values, sorts, err = Cursor{Token: token, Sort: []string{"age DESC", "id"}}.compile(plainSchema{}, "")
+	assert.Nil(t, err)
+	assert.Equal(t, "((age > ?) OR (age = ? AND (id < ? OR id IS NULL)))", cond)
+	assert.Equal(t, []any{int64(20), int64(20), int64(3)}, values)
+	assert.Equal(t, []string{"age ASC", "id DESC"}, sorts)
+	token, err = encodeCursor([]any{nil, uint(3)}, false)
+	assert.Nil(t, err)
+	cond, values, sorts, err = Cursor{Token: token, Sort: []string{"nick", "id"}}.compile(&DBSchema{DB: sqliteDB(t)}, "")
+	assert.Nil(t, err)
+	assert.Equal(t, "((`nick` IS NOT NULL) OR (`nick` IS NULL AND `id` > ?))", cond)
+	assert.Equal(t, []any{int64(3)}, values)
+	assert.Equal(t, []string{"`nick` ASC", "`id` ASC"}, sorts)
+	cond, values, sorts, err = Cursor{Token: token, Sort: []string{"nick DESC", "id DESC"}}.compile(plainSchema{}, "postgres")
+	assert.Nil(t, err)
+	assert.Equal(t, "((nick IS NULL AND (id < ? OR id IS NULL)))", cond)
+	assert.Equal(t, []any{int64(3)}, values)
+	assert.Equal(t, []string{"nick DESC NULLS LAST", "id DESC NULLS LAST"}, sorts)
+	token, err = encodeCursor([]any{nil}, false)
+	assert.Nil(t, err)
+	cond, _, _, err = Cursor{Token: token, Sort: []string{"nick DESC"}}.compile(plainSchema{}, "")
+	assert.Nil(t, err)
+	assert.Equal(t, "1 <> 1", cond)
+}
+
+func testFindPageNulls(t *testing.T, repo RDBRepository) {
+	ctx := context.Background()
+	nick := func(s string) *string { return &s }
+	assert.Nil(t, repo.Create(ctx, []*Member{{Nick: nick("b")}, {}, {Nick: nick("a")}, {}, {Nick: nick("b")}}))
+	for sort, expected := range map[string][]uint{
+		"nick":      {2, 4, 3, 1, 5},
+		"nick DESC": {1, 5, 3, 2, 4},
+	} {
+		var forward []uint
+		var p CursorPage
+		for i := 0; i < 5; i++ {
+			var members []Member
+			var err error
+			p, err = repo.FindPage(ctx, &members, func(opts *MatchOptions, schema Schema) {
+				opts.SetCursor(p.Next, sort, "id").SetLimit(1)
+			})
+			assert.Nil(t, err)
+			forward = append(forward, ids(members)...)
+		}
+		assert.Equal(t, expected, forward, sort)
+		assert.Empty(t, p.Next, sort)
+		var backward []uint
+		for p.Prev != "" {
+			var members []Member
+			var err error
+			p, err = repo.FindPage(ctx, &members, func(opts *MatchOptions, schema Schema) {
+				opts.SetCursor(p.Prev, sort, "id").SetLimit(1)
+			})
+			assert.Nil(t, err)
+			backward = append(ids(members), backward...)
+		}
+		assert.Equal(t, expected[:4], backward, sort)
+	}
+}
+
+func TestFindPage_Nulls(t *testing.T) {
+	testFindPageNulls(t, New(sqliteDB(t, &Member{}), &Member{}))
+}
+
+func TestMemoryRepository_FindPageNulls(t *testing.T) {
+	testFindPageNulls(t, NewMemory(&Member{}))
+}
@@ -2,10 +2,13 @@ package repository
 
 import (
 	"context"
+	"database/sql"
 	"errors"
 	"fmt"
 	"reflect"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/yang-zzhong/structs"
 	"github.com/yang-zzhong/xl/utils"
@@ -22,6 +25,12 @@ var operatorMap = map[Operator]string{
 	IN:    "IN",
 	NOTIN: "NOT IN",
 	LIKE:  "LIKE",
+
+	BETWEEN:    "BETWEEN",
+	NOTBETWEEN: "NOT BETWEEN",
+	NOTLIKE:    "NOT LIKE",
+	ILIKE:      "ILIKE",
+	REGEXP:     "REGEXP",
 }
 
 type tableNamer interface {
@@ -34,14 +43,38 @@ type GormStack interface {
 }
 
 type dbrepo struct {
-	db    *gorm.DB
-	table string
-	model any
+	db      *gorm.DB
+	table   string
+	model   any
+	options options
+	mu      sync.RWMutex
+	stack   []*gorm.DB
 }
 
+var _ GormStack = &dbrepo{}
+var _ Transactor = &dbrepo{}
+
 type RDBRepository interface {
 	Repository
 	TableSetter
+	// FindPage find the records of the page described by the cursor of the match options, see MatchOptions.SetCursor
+	FindPage(ctx context.Context, v any, opts ...MatchOption) (CursorPage, error)
+	// Paginate count the records and find the records of the page in one call, page starts from 1
+	Paginate(ctx context.Context, v any, page, size int, opts ...MatchOption) (Page, error)
+	// Each scan the records one by one into v and call fn after each scan, return ErrStopIteration from fn to stop
+	Each(ctx context.Context, v any, fn func() error, opts ...MatchOption) error
+	// FindInBatches fill v with at most size records and call fn after each batch, return ErrStopIteration from fn to stop
+	FindInBatches(ctx context.Context, v any, size int, fn func() error, opts ...MatchOption) error
+	// Upsert insert the records of v and update the ones conflicted on conflictColumns with updateFields
+	Upsert(ctx context.Context, v any, conflictColumns, updateFields []string) (UpsertResult, error)
+	// CreateInBatches create the records of the slice v in chunks of size, a *BatchError describes the failed chunks
+	CreateInBatches(ctx context.Context, v any, size int, opts ...BatchOption) error
+	// Restore the soft deleted records
+	Restore(ctx context.Context, opts ...MatchOption) error
+	// ForceDelete remove the records even if the repository soft deletes
+	ForceDelete(ctx context.Context, opts ...MatchOption) error
+	// Aggregate compute the aggregates of the records into the scalars or the maps keyed by the group
+	Aggregate(ctx context.Context, model *Model, opts ...MatchOption) error
 }
 
 // NewRepository
@@ -70,78 +103,265 @@ type RDBRepository interface {
 //	  	}
 //	  }
 //	  err := repo.Find(ctx, database.M(&books, &Book{}).With(&User{}, AuthorID(Field("users.id"))), Limit(20))
-func New(db *gorm.DB, model ...any) RDBRepository {
-	return &dbrepo{db: db, model: func() any {
-		if len(model) > 0 {
-			return model[0]
-		}
-		return nil
-	}()}
+//	  // options
+//	  repo := New(db, &User{}, WithQueryTimeout(time.Second))
+func New(db *gorm.DB, args ...any) RDBRepository {
+	model, opts := splitArgs(args)
+	return &dbrepo{db: db, model: model, options: opts}
 }
 
-func NewWithTable(db *gorm.DB, tableName string, model ...any) RDBRepository {
-	return &dbrepo{db: db, table: tableName, model: func() any {
-		if len(model) > 0 {
-			return model[0]
-		}
-		return nil
-	}()}
+func NewWithTable(db *gorm.DB, tableName string, args ...any) RDBRepository {
+	model, opts := splitArgs(args)
+	return &dbrepo{db: db, table: tableName, model: model, options: opts}
 }
 
 func (db *dbrepo) SetTable(table string) {
 	db.table = table
 }
 
+func (db *dbrepo) ModelName() string {
+	if db.table != "" {
+		return db.table
+	}
+	if db.model == nil {
+		return ""
+	}
+	return db.tableName(db.model)
+}
+
+// Push make the repository use db until Pop, it's useful for joining a transaction
+// which is not carried by the context
+func (db *dbrepo) Push(gdb *gorm.DB) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.stack = append(db.stack, gdb)
+}
+
+func (db *dbrepo) Pop() {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if len(db.stack) > 0 {
+		db.stack = db.stack[:len(db.stack)-1]
+	}
+}
+
+func (db *dbrepo) WithTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	return NewTransactor(db.conn(ctx)).WithTx(ctx, fn)
+}
+
 func (db *dbrepo) First(ctx context.Context, v any, opts ...MatchOption) error {
-	selector, result := db.prepare(v)
+	ctx, cancel := db.options.context(ctx)
+	defer cancel()
+	return db.observe(ctx, "First", opts, func(ctx context.Context) (int64, error) {
+		tx := db.first(ctx, v, opts)
+		return tx.RowsAffected, db.transformError(ctx, tx.Error)
+	})
+}
+
+func (db *dbrepo) first(ctx context.Context, v any, opts []MatchOption) *gorm.DB {
+	selector, result := db.prepare(ctx, v)
 	db.applyOptions(selector, opts...)
-	return db.transformError(selector.First(result).Error)
+	return selector.First(result)
 }
 
 func (db *dbrepo) Find(ctx context.Context, v any, opts ...MatchOption) error {
-	selector, result := db.prepare(v)
+	ctx, cancel := db.options.context(ctx)
+	defer cancel()
+	return db.observe(ctx, "Find", opts, func(ctx context.Context) (int64, error) {
+		tx := db.find(ctx, v, opts)
+		return tx.RowsAffected, db.transformError(ctx, tx.Error)
+	})
+}
+
+func (db *dbrepo) find(ctx context.Context, v any, opts []MatchOption) *gorm.DB {
+	selector, result := db.prepare(ctx, v)
 	db.applyOptions(selector, opts...)
-	return db.transformError(selector.Find(result).Error)
+	return selector.Find(result)
+}
+
+func (db *dbrepo) FindPage(ctx context.Context, v any, opts ...MatchOption) (CursorPage, error) {
+	var p CursorPage
+	err := db.observe(ctx, "FindPage", opts, func(ctx context.Context) (int64, error) {
+		var err error
+		p, err = findPage(ctx, db.Find, db.db.NamingStrategy, db.schema(), v, opts)
+		return 0, err
+	})
+	return p, err
 }
 
-func (db *dbrepo) transformError(err error) error {
+// Paginate run the count and the find in a transaction, so the total agrees with the items.
+// the count of a grouped model counts the groups
+func (db *dbrepo) Paginate(ctx context.Context, v any, page, size int, opts ...MatchOption) (Page, error) {
+	ctx, cancel := db.options.context(ctx)
+	defer cancel()
+	var p Page
+	err := db.observe(ctx, "Paginate", opts, func(ctx context.Context) (int64, error) {
+		err := db.WithTx(ctx, func(ctx context.Context) error {
+			var err error
+			p, err = paginate(ctx, func(ctx context.Context) (int64, error) {
+				var total int64
+				counter, _ := db.prepare(ctx, v)
+				db.applyOptions(counter, append(append([]MatchOption{}, opts...), unpaged)...)
+				if m, ok := v.(*Model); ok && m.Grp != nil {
+					counter = db.conn(ctx).Table("(?) AS t", counter)
+				}
+				return total, counter.Count(&total).Error
+			}, db.Find, v, page, size, opts)
+			return err
+		})
+		return 0, db.transformError(ctx, err)
+	})
+	return p, err
+}
+
+// transformError map the error of gorm to the errors of the package, it's ErrCanceled only if it's
+// caused by the cancellation of ctx, a failure which happens to race with the deadline is kept
+func (db *dbrepo) transformError(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
 	if errors.Is(err, gorm.ErrRecordNotFound) {
 		return ErrRecordNotFound
 	}
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return ErrDuplicatedKey
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return &canceledError{err: err}
+	}
+	// database/sql rolls back the transaction once its ctx is done
+	if errors.Is(err, sql.ErrTxDone) {
+		if cerr := contextError(ctx); cerr != nil {
+			return cerr
+		}
+	}
 	return err
 }
 
 // db.Count(ctx, database.M(result, &User{}))
 func (db *dbrepo) Count(ctx context.Context, v any, opts ...MatchOption) error {
-	selector, result := db.prepare(v)
+	ctx, cancel := db.options.context(ctx)
+	defer cancel()
+	return db.observe(ctx, "Count", opts, func(ctx context.Context) (int64, error) {
+		tx := db.count(ctx, v, opts)
+		return tx.RowsAffected, db.transformError(ctx, tx.Error)
+	})
+}
+
+func (db *dbrepo) count(ctx context.Context, v any, opts []MatchOption) *gorm.DB {
+	selector, result := db.prepare(ctx, v)
 	count, ok := result.(*int64)
 	if !ok {
-		return errors.New("count only support *int64 as result")
+		selector.AddError(errors.New("count only support *int64 as result"))
+		return selector
 	}
 	db.applyOptions(selector, opts...)
-	return db.transformError(selector.Count(count).Error)
+	return selector.Count(count)
 }
 
 func (db *dbrepo) Update(ctx context.Context, v any) error {
-	saver := db.getDBForUpdate()
-	return db.transformError(saver.Save(v).Error)
+	ctx, cancel := db.options.context(ctx)
+	defer cancel()
+	return db.observe(ctx, "Update", nil, func(ctx context.Context) (rows int64, err error) {
+		err = db.outbox(ctx, Updated, v, nil, func(ctx context.Context) error {
+			saver := db.getDBForUpdate(ctx)
+			if f := db.versionField(v); f != nil {
+				if err := db.updateVersioned(ctx, saver, f, v); err != nil {
+					return err
+				}
+				rows = 1
+				return nil
+			}
+			tx := saver.Save(v)
+			rows = tx.RowsAffected
+			return tx.Error
+		})
+		return rows, db.transformError(ctx, err)
+	})
 }
 
 func (db *dbrepo) Delete(ctx context.Context, opts ...MatchOption) error {
-	deletor := db.getDB()
+	ctx, cancel := db.options.context(ctx)
+	defer cancel()
+	return db.observe(ctx, "Delete", opts, func(ctx context.Context) (rows int64, err error) {
+		err = db.outbox(ctx, Deleted, nil, opts, func(ctx context.Context) error {
+			tx := db.delete(ctx, opts)
+			rows = tx.RowsAffected
+			return tx.Error
+		})
+		return rows, db.transformError(ctx, err)
+	})
+}
+
+func (db *dbrepo) delete(ctx context.Context, opts []MatchOption) *gorm.DB {
+	deletor := db.getDB(ctx)
 	db.applyOptions(deletor, opts...)
-	return db.transformError(deletor.Delete(db.model).Error)
+	if column := db.softDelete(); column != "" {
+		// the scope of the trashed records makes gorm miss the missing where clause
+		if !hasMatches(db.schema(), opts) {
+			deletor.AddError(gorm.ErrMissingWhereClause)
+			return deletor
+		}
+		return deletor.UpdateColumn(column, time.Now())
+	}
+	return deletor.Delete(db.model)
 }
 
 func (db *dbrepo) Create(ctx context.Context, v any) error {
-	creator := db.getDB()
-	return db.transformError(creator.Create(v).Error)
+	ctx, cancel := db.options.context(ctx)
+	defer cancel()
+	return db.observe(ctx, "Create", nil, func(ctx context.Context) (rows int64, err error) {
+		err = db.outbox(ctx, Created, v, nil, func(ctx context.Context) error {
+			tx := db.getDB(ctx).Create(v)
+			rows = tx.RowsAffected
+			return tx.Error
+		})
+		return rows, db.transformError(ctx, err)
+	})
 }
 
 func (db *dbrepo) UpdateFields(ctx context.Context, fields Fields, opts ...MatchOption) error {
-	updator := db.getDB()
+	ctx, cancel := db.options.context(ctx)
+	defer cancel()
+	return db.observe(ctx, "UpdateFields", opts, func(ctx context.Context) (rows int64, err error) {
+		err = db.outbox(ctx, FieldsUpdated, fields, opts, func(ctx context.Context) error {
+			tx, versioned := db.updateFields(ctx, fields, opts)
+			rows = tx.RowsAffected
+			if tx.Error == nil && versioned && tx.RowsAffected == 0 {
+				return ErrStaleRecord
+			}
+			return tx.Error
+		})
+		return rows, db.transformError(ctx, err)
+	})
+}
+
+// updateFields update the fields, versioned tells whether the records of the version given in fields are updated
+func (db *dbrepo) updateFields(ctx context.Context, fields Fields, opts []MatchOption) (tx *gorm.DB, versioned bool) {
+	updator := db.getDB(ctx)
 	db.applyOptions(updator, opts...)
-	return updator.Updates(map[string]any(fields)).Error
+	if f := db.versionField(db.model); f != nil {
+		return db.updateFieldsVersioned(updator, f, fields)
+	}
+	return updator.Updates(map[string]any(fields)), false
+}
+
+// outbox run the write, the change event is stored in the outbox table in the same
+// transaction if the repository is created WithOutbox
+func (db *dbrepo) outbox(ctx context.Context, typ ChangeType, data any, opts []MatchOption, write func(ctx context.Context) error) error {
+	if !db.options.outbox {
+		return write(ctx)
+	}
+	return db.WithTx(ctx, func(ctx context.Context) error {
+		if err := write(ctx); err != nil {
+			return err
+		}
+		msg, err := newOutboxMessage(changeEvent(db, typ, data, opts))
+		if err != nil {
+			return err
+		}
+		return db.conn(ctx).Create(msg).Error
+	})
 }
 
 func (repo *dbrepo) tableName(v any) string {
@@ -160,75 +380,60 @@ func (repo *dbrepo) tableName(v any) string {
 	}
 }
 
-func (repo *dbrepo) compileMatchOptions(schema Schema, opt []MatchOption) (string, []interface{}) {
-	ret := ""
-	values := []interface{}{}
+// dialect get the name of the dialector, empty if the repository has no db
+func (repo *dbrepo) dialect() string {
+	if repo.db == nil || repo.db.Dialector == nil {
+		return ""
+	}
+	return repo.db.Dialector.Name()
+}
+
+func (repo *dbrepo) compileMatchOptions(schema Schema, opt []MatchOption) (string, []interface{}, error) {
 	opts := MatchOptions{schema: schema}
 	opts.Apply(opt...)
-	for i, opt := range opts.Matches {
-		switch opt.Operator {
-		case NULL:
-			if i > 0 {
-				ret += " AND "
-			}
-			ret += fmt.Sprintf("%s IS NULL", opt.Field)
-		case NOTNULL:
-			if i > 0 {
-				ret += " AND "
-			}
-			ret += fmt.Sprintf("%s IS NULL", opt.Field)
-		case OR:
-			str, subValues := repo.compileMatchOptions(schema, opt.Value.([]MatchOption))
-			ret += fmt.Sprintf(" OR %s", str)
-			values = append(values, subValues...)
-		case AND:
-			str, subValues := repo.compileMatchOptions(schema, opt.Value.([]MatchOption))
-			ret += fmt.Sprintf(" AND %s", str)
-			values = append(values, subValues...)
-		case Quote:
-			str, subValues := repo.compileMatchOptions(schema, opt.Value.([]MatchOption))
-			ret += fmt.Sprintf("(%s)", str)
-			values = append(values, subValues...)
-		default:
-			if i > 0 {
-				ret += " AND "
-			}
-			if field, ok := opt.Value.(field); ok {
-				ret += fmt.Sprintf("%s %s %s", opt.Field, operatorMap[opt.Operator], field.String(schema))
-				continue
-			}
-			values = append(values, opt.Value)
-			ret += fmt.Sprintf("%s %s ?", opt.Field, operatorMap[opt.Operator])
-		}
+	str, values, _, err := repo.compileExpr(schema, opts.Matches)
+	return str, values, err
+}
+
+// conn get the db the repository should talk to, the transaction carried by ctx
+// comes first, then the top of the stack, then the root db
+func (repo *dbrepo) conn(ctx context.Context) *gorm.DB {
+	if tx, ok := TxFromContext(ctx); ok {
+		return tx.WithContext(ctx)
 	}
-	return ret, values
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	if len(repo.stack) > 0 {
+		return repo.stack[len(repo.stack)-1].WithContext(ctx)
+	}
+	return repo.db.WithContext(ctx)
 }
 
-func (repo *dbrepo) getDB() *gorm.DB {
+func (repo *dbrepo) getDB(ctx context.Context) *gorm.DB {
 	if repo.table != "" {
-		return repo.db.Table(repo.table)
+		return repo.conn(ctx).Table(repo.table)
 	}
-	return repo.db.Model(repo.model)
+	return repo.conn(ctx).Model(repo.model)
 }
 
-func (repo *dbrepo) getDBForUpdate() *gorm.DB {
+func (repo *dbrepo) getDBForUpdate(ctx context.Context) *gorm.DB {
 	if repo.table != "" {
-		return repo.db.Table(repo.table)
+		return repo.conn(ctx).Table(repo.table)
 	}
-	return repo.db
+	return repo.conn(ctx)
 }
 
-func (repo *dbrepo) prepare(v any) (*gorm.DB, any) {
+func (repo *dbrepo) prepare(ctx context.Context, v any) (*gorm.DB, any) {
 	m, ok := v.(*Model)
 	if !ok {
-		return repo.getDB(), v
+		return repo.getDB(ctx), v
 	}
 	var model *gorm.DB
 	switch m.From.(type) {
 	case string:
-		model = repo.db.Table(m.From.(string))
+		model = repo.conn(ctx).Table(m.From.(string))
 	default:
-		model = repo.db.Model(m.From)
+		model = repo.conn(ctx).Model(m.From)
 	}
 	for _, join := range m.Joins {
 		str := ""
@@ -241,30 +446,37 @@ func (repo *dbrepo) prepare(v any) (*gorm.DB, any) {
 			str += "Inner JOIN "
 		}
 		str += repo.tableName(join.Model) + " ON "
-		condi, values := repo.compileMatchOptions(repo.schema(), join.Opts)
+		condi, values, err := repo.compileMatchOptions(repo.schema(), join.Opts)
+		if err != nil {
+			model.AddError(err)
+			return model, m.Result
+		}
 		str += condi
 		model.Joins(str, values...)
 	}
 	if m.Grp != nil {
-		model.Group(m.Grp.By)
-		if m.Grp.Having != nil {
-			condi, values := repo.compileMatchOptions(repo.schema(), m.Grp.Having)
-			model.Having(condi, values...)
-		}
+		repo.groupBy(model, m.Grp)
 	}
 	if len(m.Flds) > 0 {
 		fields := ""
+		var values []any
 		for i, f := range m.Flds {
 			if i > 0 {
 				fields += ","
 			}
 			if fi, ok := f.(field); ok {
-				fields += fi.String(repo.schema())
+				str, vs, err := repo.compileField(repo.schema(), fi)
+				if err != nil {
+					model.AddError(err)
+					return model, m.Result
+				}
+				fields += str
+				values = append(values, vs...)
 			} else if fi, ok := f.(string); ok {
 				fields += fi
 			}
 		}
-		model.Select(fields)
+		model.Select(fields, values...)
 		return model, m.Result
 	}
 	if m.Result == nil {
@@ -323,27 +535,38 @@ func (repo *dbrepo) schema() Schema {
 func (repo *dbrepo) applyOptions(db *gorm.DB, opts ...MatchOption) {
 	opt := &MatchOptions{schema: repo.schema()}
 	opt.Apply(opts...)
-	for _, match := range opt.Matches {
-		switch match.Operator {
-		case NULL:
-			db.Where(fmt.Sprintf("%s IS NULL", match.Field))
-		case NOTNULL:
-			db.Where(fmt.Sprintf("%s IS NOT NULL", match.Field))
-		case OR:
-			str, values := repo.compileMatchOptions(repo.schema(), match.Value.([]MatchOption))
-			db.Or(str, values...)
-		case Quote:
-			str, values := repo.compileMatchOptions(repo.schema(), match.Value.([]MatchOption))
-			db.Where(str, values...)
-		case AND:
-			str, values := repo.compileMatchOptions(repo.schema(), match.Value.([]MatchOption))
-			db.Where(str, values...)
-		default:
-			db.Where(fmt.Sprintf("%s %s ?", match.Field, operatorMap[match.Operator]), match.Value)
+	// the soft delete of gorm is replaced by the trashed scope
+	if column := repo.softDelete(); column != "" {
+		db.Unscoped()
+		column = repo.schema().Field(column)
+		if err := opt.scopeTrashed(column); err != nil {
+			db.AddError(err)
+			return
 		}
+	} else if err := opt.scopeTrashed(""); err != nil {
+		db.AddError(err)
+		return
 	}
-	if len(opt.Sort) > 0 {
-		db.Order(strings.Join(opt.Sort, ","))
+	str, values, _, err := repo.compileExpr(repo.schema(), opt.Matches)
+	if err != nil {
+		db.AddError(err)
+		return
+	}
+	if str != "" {
+		db.Where(str, values...)
+	}
+	if opt.Cursor != nil {
+		cond, values, sorts, err := opt.Cursor.compile(repo.schema(), repo.dialect())
+		if err != nil {
+			db.AddError(err)
+			return
+		}
+		if cond != "" {
+			db.Where(cond, values...)
+		}
+		db.Order(strings.Join(sorts, ","))
+	} else if len(opt.Sort) > 0 || len(opt.Order) > 0 {
+		repo.orderBy(db, opt.Sort, opt.Order)
 	}
 	if opt.Limit != nil {
 		db.Limit(*opt.Limit)
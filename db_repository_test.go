@@ -4,6 +4,8 @@ import (
 	"context"
 	"database/sql"
 	"database/sql/driver"
+	"errors"
+	"fmt"
 	"reflect"
 	"testing"
 	"time"
@@ -45,7 +47,7 @@ func Like(name string) MatchOption {
 	}
 }
 
-func Or(name string) MatchOption {
+func OrLike(name string) MatchOption {
 	return func(opts *MatchOptions, schema Schema) {
 		opts.Quote(func(opts *MatchOptions, schema Schema) {
 			opts.LIKE("user.name", "%"+name+"%").OR(func(opts *MatchOptions, schema Schema) {
@@ -251,7 +253,7 @@ func TestOr(t *testing.T) {
 			WillReturnRows(sqlmock.NewRows([]string{"author_id", "books"}))
 	}()
 	result := []*Book{}
-	err = repo.Find(context.Background(), &result, AuthorID([]string{"1", "2", "3"}), Or("hello"))
+	err = repo.Find(context.Background(), &result, AuthorID([]string{"1", "2", "3"}), OrLike("hello"))
 	assert.Nil(t, err)
 }
 
@@ -362,3 +364,33 @@ func TestGormRepository_Create(t *testing.T) {
 	err = repo.Create(context.Background(), &Book{ID: "hello"})
 	assert.Nil(t, err)
 }
+
+func TestGormRepository_QueryTimeout(t *testing.T) {
+	repo := New(sqliteDB(t, &Book{}), &Book{}, WithQueryTimeout(10*time.Millisecond))
+	assert.Nil(t, repo.Create(context.Background(), &Book{ID: "1"}))
+	var books []Book
+	err := repo.Find(context.Background(), &books, func(opts *MatchOptions, schema Schema) {
+		// never ends until it's interrupted
+		opts.Expr("(WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x + 1 FROM c) SELECT COUNT(*) FROM c) > 0")
+	})
+	assert.True(t, errors.Is(err, ErrCanceled))
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = repo.Create(ctx, &Book{ID: "hello"})
+	assert.True(t, errors.Is(err, ErrCanceled))
+	assert.True(t, errors.Is(err, context.Canceled))
+}
+
+func TestGormRepository_transformError(t *testing.T) {
+	repo := &dbrepo{}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	failed := errors.New("constraint failed")
+	assert.Equal(t, failed, repo.transformError(ctx, failed))
+	assert.Equal(t, ErrDuplicatedKey, repo.transformError(ctx, gorm.ErrDuplicatedKey))
+	assert.Equal(t, ErrRecordNotFound, repo.transformError(ctx, gorm.ErrRecordNotFound))
+	assert.True(t, errors.Is(repo.transformError(ctx, sql.ErrTxDone), ErrCanceled))
+	assert.Equal(t, sql.ErrTxDone, repo.transformError(context.Background(), sql.ErrTxDone))
+	assert.True(t, errors.Is(repo.transformError(context.Background(), fmt.Errorf("query: %w", context.DeadlineExceeded)), ErrCanceled))
+}
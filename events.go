@@ -0,0 +1,212 @@
+// Copyright (c) 2024 Yang,Zhong
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+package repository
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+type ChangeType int
+
+const (
+	Created ChangeType = iota + 1
+	Updated
+	Deleted
+	FieldsUpdated
+	Upserted
+	Restored
+)
+
+var changeTypeNames = map[ChangeType]string{
+	Created:       "created",
+	Updated:       "updated",
+	Deleted:       "deleted",
+	FieldsUpdated: "fields-updated",
+	Upserted:      "upserted",
+	Restored:      "restored",
+}
+
+func (t ChangeType) String() string {
+	if name, ok := changeTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("ChangeType(%d)", int(t))
+}
+
+type ChangeEvent struct {
+	// ChangeData.Data is the value of Created, Updated and Upserted, the Fields of FieldsUpdated
+	ChangeData
+	Type ChangeType
+	// Filter is the match filter of Deleted, FieldsUpdated and Restored
+	Filter MatchOptions
+	Time   time.Time
+}
+
+type Publisher interface {
+	Publish(ctx context.Context, events ...ChangeEvent) error
+}
+
+type Subscriber func(ctx context.Context, event ChangeEvent) error
+
+type EventBus interface {
+	Publisher
+	// Subscribe handle the events of models, the events of all models are handled if no model is given.
+	// call unsubscribe to stop handling
+	Subscribe(handler Subscriber, models ...string) (unsubscribe func())
+}
+
+type subscription struct {
+	handler Subscriber
+	models  map[string]bool
+}
+
+type eventBus struct {
+	mu            sync.RWMutex
+	subscriptions map[int]subscription
+	next          int
+}
+
+var _ EventBus = &eventBus{}
+
+// NewEventBus create an in-process EventBus, the subscribers are called synchronously in Publish
+func NewEventBus() EventBus {
+	return &eventBus{subscriptions: make(map[int]subscription)}
+}
+
+func (bus *eventBus) Subscribe(handler Subscriber, models ...string) func() {
+	s := subscription{handler: handler}
+	if len(models) > 0 {
+		s.models = make(map[string]bool)
+		for _, model := range models {
+			s.models[model] = true
+		}
+	}
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	id := bus.next
+	bus.next++
+	bus.subscriptions[id] = s
+	return func() {
+		bus.mu.Lock()
+		defer bus.mu.Unlock()
+		delete(bus.subscriptions, id)
+	}
+}
+
+// Publish call every matched subscriber even if some of them fail, the first error is returned
+func (bus *eventBus) Publish(ctx context.Context, events ...ChangeEvent) error {
+	bus.mu.RLock()
+	subscriptions := make([]subscription, 0, len(bus.subscriptions))
+	for i := 0; i < bus.next; i++ {
+		if s, ok := bus.subscriptions[i]; ok {
+			subscriptions = append(subscriptions, s)
+		}
+	}
+	bus.mu.RUnlock()
+	var first error
+	for _, event := range events {
+		for _, s := range subscriptions {
+			if s.models != nil && !s.models[event.Model] {
+				continue
+			}
+			if err := s.handler(ctx, event); err != nil && first == nil {
+				first = err
+			}
+		}
+	}
+	return first
+}
+
+type publishingRepo struct {
+	repo      Repository
+	publisher Publisher
+}
+
+var _ Repository = &publishingRepo{}
+
+// Publishing publish a ChangeEvent to publisher after each successful write of repo,
+// the write is not reverted if the publishing fails, the error is returned though.
+// the writes in a transaction started by WithTx, or one whose hooks are bound by ContextWithAfterCommit,
+// are published after it commits, nothing is published if it rolls back. the events are lost if the
+// process exits between the commit and the publishing, use WithOutbox where they can't be.
+// usage:
+//
+//	bus := NewEventBus()
+//	bus.Subscribe(func(ctx context.Context, event ChangeEvent) error {
+//		log.Printf("%s %s", event.Model, event.Type)
+//		return nil
+//	}, "users")
+//	repo := Publishing(New(db, &User{}), bus)
+func Publishing(repo Repository, publisher Publisher) Repository {
+	return &publishingRepo{repo: repo, publisher: publisher}
+}
+
+func (p *publishingRepo) First(ctx context.Context, v any, opts ...MatchOption) error {
+	return p.repo.First(ctx, v, opts...)
+}
+
+func (p *publishingRepo) Find(ctx context.Context, v any, opts ...MatchOption) error {
+	return p.repo.Find(ctx, v, opts...)
+}
+
+func (p *publishingRepo) Count(ctx context.Context, v any, opts ...MatchOption) error {
+	return p.repo.Count(ctx, v, opts...)
+}
+
+func (p *publishingRepo) Update(ctx context.Context, v any) error {
+	if err := p.repo.Update(ctx, v); err != nil {
+		return err
+	}
+	return p.publish(ctx, changeEvent(p.repo, Updated, v, nil))
+}
+
+func (p *publishingRepo) Delete(ctx context.Context, opts ...MatchOption) error {
+	if err := p.repo.Delete(ctx, opts...); err != nil {
+		return err
+	}
+	return p.publish(ctx, changeEvent(p.repo, Deleted, nil, opts))
+}
+
+func (p *publishingRepo) Create(ctx context.Context, v any) error {
+	if err := p.repo.Create(ctx, v); err != nil {
+		return err
+	}
+	return p.publish(ctx, changeEvent(p.repo, Created, v, nil))
+}
+
+func (p *publishingRepo) UpdateFields(ctx context.Context, fields Fields, opts ...MatchOption) error {
+	if err := p.repo.UpdateFields(ctx, fields, opts...); err != nil {
+		return err
+	}
+	return p.publish(ctx, changeEvent(p.repo, FieldsUpdated, fields, opts))
+}
+
+// publish the event after the transaction in ctx commits, so the subscribers never see
+// the changes rolled back
+func (p *publishingRepo) publish(ctx context.Context, event ChangeEvent) error {
+	return AfterCommit(ctx, func(ctx context.Context) error {
+		return p.publisher.Publish(ctx, event)
+	})
+}
+
+func changeEvent(repo Repository, typ ChangeType, data any, opts []MatchOption) ChangeEvent {
+	var model string
+	if namer, ok := repo.(ModelNamer); ok {
+		model = namer.ModelName()
+	}
+	event := ChangeEvent{
+		ChangeData: ChangeData{Model: model, Data: data},
+		Type:       typ,
+		Time:       time.Now(),
+	}
+	if opts != nil {
+		event.Filter = MatchOptions{schema: plainSchema{table: model}}
+		event.Filter.Apply(opts...)
+	}
+	return event
+}
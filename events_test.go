@@ -0,0 +1,72 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPublishing(t *testing.T) {
+	ctx := context.Background()
+	bus := NewEventBus()
+	var books, all []ChangeEvent
+	bus.Subscribe(func(ctx context.Context, event ChangeEvent) error {
+		books = append(books, event)
+		return nil
+	}, "books")
+	unsubscribe := bus.Subscribe(func(ctx context.Context, event ChangeEvent) error {
+		all = append(all, event)
+		return nil
+	})
+	repo := Publishing(NewMemory(&Book{}), bus)
+	users := Publishing(NewMemory(&User{}), bus)
+	book := &Book{ID: "1", AuthorID: "1"}
+	assert.Nil(t, repo.Create(ctx, book))
+	assert.Nil(t, users.Create(ctx, &User{ID: "1"}))
+	assert.Nil(t, repo.UpdateFields(ctx, Fields{"name": "hello"}, AuthorID("1")))
+	unsubscribe()
+	assert.Nil(t, repo.Delete(ctx, AuthorID("1")))
+	assert.Len(t, all, 3)
+	assert.Len(t, books, 3)
+	assert.Equal(t, Created, books[0].Type)
+	assert.Equal(t, "books", books[0].Model)
+	assert.Equal(t, book, books[0].Data)
+	assert.Equal(t, FieldsUpdated, books[1].Type)
+	assert.Equal(t, Fields{"name": "hello"}, books[1].Data)
+	assert.Equal(t, Deleted, books[2].Type)
+	assert.Equal(t, []MatchItem{{Field: "books.author_id", Operator: EQ, Value: "1"}}, books[2].Filter.Matches)
+	assert.False(t, books[2].Time.IsZero())
+	assert.Equal(t, "users", all[1].Model)
+}
+
+func TestPublishing_Transaction(t *testing.T) {
+	ctx := context.Background()
+	db := sqliteDB(t, &Book{})
+	bus := NewEventBus()
+	var events []ChangeEvent
+	bus.Subscribe(func(ctx context.Context, event ChangeEvent) error {
+		events = append(events, event)
+		return nil
+	}, "books")
+	repo := Publishing(New(db, &Book{}), bus)
+	tr := NewTransactor(db)
+	err := tr.WithTx(ctx, func(ctx context.Context) error {
+		if err := repo.Create(ctx, &Book{ID: "1"}); err != nil {
+			return err
+		}
+		assert.Equal(t, 0, len(events))
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(events))
+	err = tr.WithTx(ctx, func(ctx context.Context) error {
+		if err := repo.Create(ctx, &Book{ID: "2"}); err != nil {
+			return err
+		}
+		return errors.New("rollback")
+	})
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, len(events))
+}
@@ -0,0 +1,137 @@
+// Copyright (c) 2024 Yang,Zhong
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+package repository
+
+import (
+	"fmt"
+	"strings"
+)
+
+// And match the records which match all of the options, the options are parenthesized
+// as a whole wherever the group is used
+// usage:
+//
+//	repo.Find(ctx, &books, Or(
+//		And(func(opts *MatchOptions, schema Schema) { opts.EQ(schema.Field("author_id"), 1) }),
+//		Not(func(opts *MatchOptions, schema Schema) { opts.NotNull(schema.Field("published_at")) }),
+//	))
+func And(opts ...MatchOption) MatchOption {
+	return func(o *MatchOptions, schema Schema) {
+		o.Matches = append(o.Matches, MatchItem{Operator: ALL, Value: opts})
+	}
+}
+
+// Or match the records which match any of the options, each option is a group of its own
+func Or(opts ...MatchOption) MatchOption {
+	disjuncts := make([]MatchOption, len(opts))
+	for i, opt := range opts {
+		disjuncts[i] = And(opt)
+	}
+	return func(o *MatchOptions, schema Schema) {
+		o.Matches = append(o.Matches, MatchItem{Operator: ANY, Value: disjuncts})
+	}
+}
+
+// Not match the records which don't match all of the options
+func Not(opts ...MatchOption) MatchOption {
+	return func(o *MatchOptions, schema Schema) {
+		o.Matches = append(o.Matches, MatchItem{Operator: NOT, Value: opts})
+	}
+}
+
+// Expr match the records with the raw sql, the values are bound to the placeholders of it.
+// the sql is kept as it is and parenthesized, so the fields in it must be qualified by hand
+// where needed
+// usage:
+//
+//	repo.Find(ctx, &books, func(opts *MatchOptions, schema Schema) {
+//		opts.Expr("DATE("+schema.Field("created_at")+") = ?", day)
+//	})
+func (opts *MatchOptions) Expr(sql string, values ...any) *MatchOptions {
+	return opts.oper(sql, EXPR, values)
+}
+
+// compileExpr compile the items into one condition shared by WHERE, JOIN ON and HAVING.
+// the items are joined by AND, an OR item starts a new group as the positional OR always
+// does, and the groups are joined by OR. the nested groups are parenthesized, so the
+// precedence follows the tree instead of the order of the calls. n is the number of terms
+func (repo *dbrepo) compileExpr(schema Schema, items []MatchItem) (str string, values []any, n int, err error) {
+	var groups []string
+	var current []string
+	for _, item := range items {
+		if item.Operator == OR && len(current) > 0 {
+			groups = append(groups, strings.Join(current, " AND "))
+			current = nil
+		}
+		term, vs, err := repo.compileTerm(schema, item)
+		if err != nil {
+			return "", nil, 0, err
+		}
+		if term == "" {
+			continue
+		}
+		current = append(current, term)
+		values = append(values, vs...)
+		n++
+	}
+	if len(current) > 0 {
+		groups = append(groups, strings.Join(current, " AND "))
+	}
+	return strings.Join(groups, " OR "), values, n, nil
+}
+
+func (repo *dbrepo) compileTerm(schema Schema, item MatchItem) (string, []any, error) {
+	switch item.Operator {
+	case NULL:
+		return fmt.Sprintf("%s IS NULL", item.Field), nil, nil
+	case NOTNULL:
+		return fmt.Sprintf("%s IS NOT NULL", item.Field), nil, nil
+	case OR, AND, Quote, ALL:
+		str, values, n, err := repo.compileGroup(schema, item.Value)
+		if n > 1 {
+			str = "(" + str + ")"
+		}
+		return str, values, err
+	case ANY:
+		sub := MatchOptions{schema: schema}
+		opts, _ := item.Value.([]MatchOption)
+		sub.Apply(opts...)
+		var terms []string
+		var values []any
+		for _, m := range sub.Matches {
+			term, vs, err := repo.compileTerm(schema, m)
+			if err != nil {
+				return "", nil, err
+			}
+			if term == "" {
+				continue
+			}
+			terms = append(terms, term)
+			values = append(values, vs...)
+		}
+		if len(terms) > 1 {
+			return "(" + strings.Join(terms, " OR ") + ")", values, nil
+		}
+		return strings.Join(terms, ""), values, nil
+	case NOT:
+		str, values, _, err := repo.compileGroup(schema, item.Value)
+		if err != nil || str == "" {
+			return "", nil, err
+		}
+		return "NOT (" + str + ")", values, nil
+	case EXPR:
+		values, _ := item.Value.([]any)
+		// the raw sql is always parenthesized, so its own AND or OR can't change the precedence
+		return "(" + item.Field + ")", values, nil
+	}
+	return repo.compileItem(schema, item)
+}
+
+func (repo *dbrepo) compileGroup(schema Schema, value any) (string, []any, int, error) {
+	opts, _ := value.([]MatchOption)
+	sub := MatchOptions{schema: schema}
+	sub.Apply(opts...)
+	return repo.compileExpr(schema, sub.Matches)
+}
@@ -0,0 +1,140 @@
+package repository
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestGormRepository_Expr(t *testing.T) {
+	db, mock, err := sqlmock.New() // mock sql.DB
+	assert.Nil(t, err)
+	defer db.Close()
+	defer assert.Nil(t, mock.ExpectationsWereMet())
+	gdb, err := gorm.Open(dialector(db)) // open gorm db
+	assert.Nil(t, err)
+	repo := New(gdb, &Book{})
+	func() {
+		execSql := "^SELECT \\* FROM `books` WHERE `books`.`name` = \\? AND \\(`books`.`author_id` = \\? OR \\(`books`.`id` > \\? AND `books`.`author_id` IS NOT NULL\\) OR NOT \\(`books`.`id` IN \\(\\?,\\?\\)\\)\\)$"
+		mock.ExpectQuery(execSql).
+			WithArgs("go", "1", "5", "2", "3").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "author_id"}))
+	}()
+	var books []Book
+	err = repo.Find(context.Background(), &books, func(opts *MatchOptions, schema Schema) {
+		opts.EQ(schema.Field("name"), "go")
+	}, Or(
+		func(opts *MatchOptions, schema Schema) { opts.EQ(schema.Field("author_id"), "1") },
+		And(func(opts *MatchOptions, schema Schema) {
+			opts.GT(schema.Field("id"), "5").NotNull(schema.Field("author_id"))
+		}),
+		Not(func(opts *MatchOptions, schema Schema) { opts.IN(schema.Field("id"), []string{"2", "3"}) }),
+	))
+	assert.Nil(t, err)
+}
+
+func testExpr(t *testing.T, repo RDBRepository) {
+	ctx := context.Background()
+	assert.Nil(t, repo.Create(ctx, []*Member{{Name: "a", Age: 10}, {Name: "b", Age: 20}, {Name: "c", Age: 30}, {Name: "d", Age: 40}}))
+	names := func(opts ...MatchOption) []string {
+		var members []Member
+		assert.Nil(t, repo.Find(ctx, &members, append(opts, func(opts *MatchOptions, schema Schema) { opts.SetSort("id") })...))
+		var ret []string
+		for _, m := range members {
+			ret = append(ret, m.Name)
+		}
+		return ret
+	}
+	age := func(min, max int) MatchOption {
+		return func(opts *MatchOptions, schema Schema) {
+			opts.GTE(schema.Field("age"), min).LTE(schema.Field("age"), max)
+		}
+	}
+	name := func(n string) MatchOption {
+		return func(opts *MatchOptions, schema Schema) { opts.EQ(schema.Field("name"), n) }
+	}
+	// the disjuncts of Or keep their conditions together
+	assert.Equal(t, []string{"a", "d"}, names(Or(age(0, 10), age(40, 50))))
+	assert.Equal(t, []string{"d"}, names(name("d"), Or(age(0, 10), age(40, 50))))
+	assert.Equal(t, []string{"b", "c"}, names(Not(Or(age(0, 10), age(40, 50)))))
+	assert.Equal(t, []string{"a", "c", "d"}, names(Not(And(name("b"), age(20, 20)))))
+	assert.Equal(t, []string{"a", "b", "c", "d"}, names(Or()))
+	assert.Equal(t, []string{"a", "b", "c", "d"}, names(func(opts *MatchOptions, schema Schema) {
+		opts.NotNull(schema.Field("name"))
+	}))
+}
+
+func TestExpr(t *testing.T) {
+	testExpr(t, New(sqliteDB(t, &Member{}), &Member{}))
+}
+
+func TestMemoryRepository_Expr(t *testing.T) {
+	testExpr(t, NewMemory(&Member{}))
+}
+
+func TestExprSum(t *testing.T) {
+	a := func(opts *MatchOptions, schema Schema) { opts.EQ("a", 1) }
+	b := func(opts *MatchOptions, schema Schema) { opts.EQ("b", 2) }
+	assert.True(t, Equal([]MatchOption{Or(a, b)}, []MatchOption{Or(a, b)}))
+	assert.False(t, Equal([]MatchOption{Or(a, b)}, []MatchOption{And(a, b)}))
+	assert.False(t, Equal([]MatchOption{Not(a)}, []MatchOption{a}))
+}
+
+func TestGormRepository_RawExpr(t *testing.T) {
+	db, mock, err := sqlmock.New() // mock sql.DB
+	assert.Nil(t, err)
+	defer db.Close()
+	defer assert.Nil(t, mock.ExpectationsWereMet())
+	gdb, err := gorm.Open(dialector(db)) // open gorm db
+	assert.Nil(t, err)
+	repo := New(gdb, &Book{})
+	func() {
+		execSql := "^SELECT \\* FROM `books` WHERE \\(JSON_CONTAINS\\(`books`.`name`, \\?\\)\\) AND \\(`books`.`author_id` = \\? OR \\(`books`.`id` > \\? OR `books`.`id` < \\?\\)\\)$"
+		mock.ExpectQuery(execSql).
+			WithArgs(`"go"`, "1", "9", "2").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "author_id"}))
+	}()
+	var books []Book
+	err = repo.Find(context.Background(), &books, func(opts *MatchOptions, schema Schema) {
+		opts.Expr("JSON_CONTAINS("+schema.Field("name")+", ?)", `"go"`)
+	}, Or(
+		func(opts *MatchOptions, schema Schema) { opts.EQ(schema.Field("author_id"), "1") },
+		func(opts *MatchOptions, schema Schema) {
+			opts.Expr(schema.Field("id")+" > ? OR "+schema.Field("id")+" < ?", "9", "2")
+		},
+	))
+	assert.Nil(t, err)
+}
+
+func TestRawExpr_Precedence(t *testing.T) {
+	ctx := context.Background()
+	repo := New(sqliteDB(t, &Member{}), &Member{})
+	assert.Nil(t, repo.Create(ctx, []*Member{{Name: "a", Age: 1}, {Name: "b", Age: 2}, {Name: "c", Age: 2}}))
+	for _, sql := range []string{"age = 1\nOR age = 2", "age = 1\tOR\tage = 2", "age = 1 or age = 2"} {
+		var members []Member
+		assert.Nil(t, repo.Find(ctx, &members, func(opts *MatchOptions, schema Schema) {
+			opts.EQ(schema.Field("name"), "b").Expr(sql)
+		}))
+		assert.Equal(t, []uint{2}, ids(members), sql)
+	}
+}
+
+func TestMemoryRepository_RawExpr(t *testing.T) {
+	var members []Member
+	err := NewMemory(&Member{}).Find(context.Background(), &members, func(opts *MatchOptions, schema Schema) {
+		opts.Expr("age > ?", 1)
+	})
+	assert.ErrorIs(t, err, ErrUnsupported)
+}
+
+func TestRawExprSum(t *testing.T) {
+	expr := func(sql string, values ...any) MatchOption {
+		return func(opts *MatchOptions, schema Schema) { opts.Expr(sql, values...) }
+	}
+	assert.Equal(t, Sum(expr("DATE(created_at) = ?", "2024-01-01")), Sum(expr("DATE(created_at) = ?", "2024-01-01")))
+	assert.NotEqual(t, Sum(expr("DATE(created_at) = ?", "2024-01-01")), Sum(expr("DATE(created_at) = ?", "2024-01-02")))
+	assert.NotEqual(t, Sum(expr("DATE(created_at) = ?", "2024-01-01")), Sum(expr("DATE(updated_at) = ?", "2024-01-01")))
+}
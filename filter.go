@@ -0,0 +1,405 @@
+// Copyright (c) 2024 Yang,Zhong
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+package repository
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+var ErrInvalidFilter = errors.New("invalid filter")
+
+// maxFilterDepth limit the nesting of the groups a client may send
+const maxFilterDepth = 8
+
+// filterOperators is the names of the operators in the json filters, the other
+// operators, such as the subqueries and the raw sql, are never accepted from clients
+var filterOperators = map[Operator]string{
+	EQ:         "eq",
+	NEQ:        "neq",
+	LT:         "lt",
+	LTE:        "lte",
+	GT:         "gt",
+	GTE:        "gte",
+	IN:         "in",
+	NOTIN:      "notin",
+	NULL:       "null",
+	NOTNULL:    "notnull",
+	LIKE:       "like",
+	NOTLIKE:    "notlike",
+	ILIKE:      "ilike",
+	REGEXP:     "regexp",
+	BETWEEN:    "between",
+	NOTBETWEEN: "notbetween",
+}
+
+// defaultFilterOperators is the operators allowed on a field of AllowList without any, REGEXP
+// is left out since a pattern of the client may be expensive to match
+var defaultFilterOperators = []Operator{EQ, NEQ, LT, LTE, GT, GTE, IN, NOTIN, NULL, NOTNULL, LIKE, NOTLIKE, ILIKE, BETWEEN, NOTBETWEEN}
+
+// AllowList map the fields clients may filter and sort on to the operators allowed on
+// them, the operators of the json filters except REGEXP are allowed on a field without
+// any, REGEXP must be listed to be allowed
+//
+//	allow := AllowList{"name": {EQ, LIKE, REGEXP}, "age": nil}
+type AllowList map[string][]Operator
+
+func (allow AllowList) check(field string, op Operator) error {
+	ops, ok := allow[field]
+	if !ok {
+		return fmt.Errorf("%w: field [%s] is not allowed", ErrInvalidFilter, field)
+	}
+	if len(ops) == 0 {
+		ops = defaultFilterOperators
+	}
+	for _, allowed := range ops {
+		if allowed == op {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: operator [%s] is not allowed on field [%s]", ErrInvalidFilter, filterOperators[op], field)
+}
+
+// jsonFilter is the json schema of the filters, a match is either a comparison or
+// one of the groups
+//
+//	{
+//		"match": [
+//			{"field": "age", "op": "gte", "value": 18},
+//			{"or": [{"field": "name", "op": "like", "value": "a%"}, {"not": [{"field": "nick", "op": "null"}]}]}
+//		],
+//		"sort": ["age desc", "id"],
+//		"limit": 10,
+//		"offset": 20
+//	}
+type jsonFilter struct {
+	Match  []jsonMatch `json:"match,omitempty"`
+	Sort   []string    `json:"sort,omitempty"`
+	Limit  *int        `json:"limit,omitempty"`
+	Offset *int        `json:"offset,omitempty"`
+}
+
+type jsonMatch struct {
+	Field string      `json:"field,omitempty"`
+	Op    string      `json:"op,omitempty"`
+	Value any         `json:"value,omitempty"`
+	And   []jsonMatch `json:"and,omitempty"`
+	Or    []jsonMatch `json:"or,omitempty"`
+	Not   []jsonMatch `json:"not,omitempty"`
+}
+
+// MarshalJSON encode the options in the json schema of the filters. the nested options are
+// resolved against the schema of the options, the fields are written as they are resolved
+func (opts MatchOptions) MarshalJSON() ([]byte, error) {
+	if opts.schema == nil {
+		opts.schema = plainSchema{}
+	}
+	if len(opts.Order) > 0 {
+		return nil, fmt.Errorf("%w: marshal the order by expressions into json", ErrUnsupported)
+	}
+	// the json filters have no cursor and trashed scope, dropping them would change the query
+	if opts.Cursor != nil {
+		return nil, fmt.Errorf("%w: marshal the cursor into json", ErrUnsupported)
+	}
+	if opts.Trashed != TrashedExcluded || opts.DeletedSince != nil {
+		return nil, fmt.Errorf("%w: marshal the trashed scope into json", ErrUnsupported)
+	}
+	matches, err := opts.jsonMatches(opts.Matches)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(jsonFilter{Match: matches, Sort: opts.Sort, Limit: opts.Limit, Offset: opts.Offset})
+}
+
+// MarshalMatchOptions encode the options in the json schema of the filters, the fields
+// are the bare names given to schema.Field, which is what ParseMatchOptions accepts
+// usage:
+//
+//	data, err := MarshalMatchOptions(func(opts *MatchOptions, schema Schema) {
+//		opts.GTE(schema.Field("age"), 18).SetLimit(10)
+//	})
+func MarshalMatchOptions(opts ...MatchOption) ([]byte, error) {
+	opt := MatchOptions{schema: plainSchema{}}
+	opt.Apply(opts...)
+	return json.Marshal(opt)
+}
+
+func (opts MatchOptions) jsonMatches(items []MatchItem) ([]jsonMatch, error) {
+	// the positional OR starts a new group of the conjuncts
+	var groups [][]jsonMatch
+	current := []jsonMatch{}
+	for _, item := range items {
+		if item.Operator == OR && len(current) > 0 {
+			groups = append(groups, current)
+			current = []jsonMatch{}
+		}
+		m, ok, err := opts.jsonMatch(item)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			current = append(current, m)
+		}
+	}
+	if len(groups) == 0 {
+		return current, nil
+	}
+	groups = append(groups, current)
+	var or []jsonMatch
+	for _, group := range groups {
+		if g, ok := jsonAnd(group); ok {
+			or = append(or, g)
+		}
+	}
+	return []jsonMatch{{Or: or}}, nil
+}
+
+func (opts MatchOptions) jsonMatch(item MatchItem) (jsonMatch, bool, error) {
+	switch item.Operator {
+	case OR, AND, Quote, ALL, ANY, NOT:
+		nested, _ := item.Value.([]MatchOption)
+		sub := MatchOptions{schema: opts.schema}
+		sub.Apply(nested...)
+		if item.Operator == ANY {
+			var or []jsonMatch
+			for _, m := range sub.Matches {
+				jm, ok, err := opts.jsonMatch(m)
+				if err != nil {
+					return jsonMatch{}, false, err
+				}
+				if ok {
+					or = append(or, jm)
+				}
+			}
+			return jsonMatch{Or: or}, len(or) > 0, nil
+		}
+		matches, err := opts.jsonMatches(sub.Matches)
+		if err != nil || len(matches) == 0 {
+			return jsonMatch{}, false, err
+		}
+		if item.Operator == NOT {
+			return jsonMatch{Not: matches}, true, nil
+		}
+		m, ok := jsonAnd(matches)
+		return m, ok, nil
+	}
+	name, ok := filterOperators[item.Operator]
+	if !ok {
+		return jsonMatch{}, false, fmt.Errorf("%w: marshal operator %d into json", ErrUnsupported, item.Operator)
+	}
+	switch item.Value.(type) {
+	case field, Func, Subquery:
+		return jsonMatch{}, false, fmt.Errorf("%w: marshal %T into json", ErrUnsupported, item.Value)
+	}
+	return jsonMatch{Field: item.Field, Op: name, Value: item.Value}, true, nil
+}
+
+// jsonAnd group the matches, a single match needn't the group
+func jsonAnd(matches []jsonMatch) (jsonMatch, bool) {
+	switch len(matches) {
+	case 0:
+		return jsonMatch{}, false
+	case 1:
+		return matches[0], true
+	}
+	return jsonMatch{And: matches}, true
+}
+
+// ParseMatchOptions decode the json filters sent by clients, only the fields and the
+// operators in allow are accepted, the errors of the filters wrap ErrInvalidFilter
+// usage:
+//
+//	opts, err := ParseMatchOptions(body, AllowList{"name": {EQ, LIKE}, "age": nil})
+//	if errors.Is(err, ErrInvalidFilter) {
+//		return http.StatusBadRequest
+//	}
+//	err = repo.Find(ctx, &users, opts...)
+func ParseMatchOptions(data []byte, allow AllowList) ([]MatchOption, error) {
+	var filter jsonFilter
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&filter); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidFilter, err.Error())
+	}
+	var ret []MatchOption
+	for _, m := range filter.Match {
+		opt, err := parseMatch(m, allow, 1)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, opt)
+	}
+	if len(filter.Sort) > 0 {
+		opt, err := parseSort(filter.Sort, allow)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, opt)
+	}
+	if filter.Limit != nil {
+		if *filter.Limit < 0 {
+			return nil, fmt.Errorf("%w: negative limit %d", ErrInvalidFilter, *filter.Limit)
+		}
+		limit := *filter.Limit
+		ret = append(ret, func(opts *MatchOptions, schema Schema) { opts.SetLimit(limit) })
+	}
+	if filter.Offset != nil {
+		if *filter.Offset < 0 {
+			return nil, fmt.Errorf("%w: negative offset %d", ErrInvalidFilter, *filter.Offset)
+		}
+		offset := *filter.Offset
+		ret = append(ret, func(opts *MatchOptions, schema Schema) { opts.SetOffset(offset) })
+	}
+	return ret, nil
+}
+
+func parseMatch(m jsonMatch, allow AllowList, depth int) (MatchOption, error) {
+	if depth > maxFilterDepth {
+		return nil, fmt.Errorf("%w: groups nested deeper than %d", ErrInvalidFilter, maxFilterDepth)
+	}
+	kinds := 0
+	for _, set := range []bool{m.Op != "" || m.Field != "", m.And != nil, m.Or != nil, m.Not != nil} {
+		if set {
+			kinds++
+		}
+	}
+	if kinds != 1 {
+		return nil, fmt.Errorf("%w: a match must be one of a comparison, and, or and not", ErrInvalidFilter)
+	}
+	group := func(matches []jsonMatch) ([]MatchOption, error) {
+		opts := make([]MatchOption, 0, len(matches))
+		for _, sub := range matches {
+			opt, err := parseMatch(sub, allow, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			opts = append(opts, opt)
+		}
+		return opts, nil
+	}
+	switch {
+	case m.And != nil:
+		opts, err := group(m.And)
+		return And(opts...), err
+	case m.Or != nil:
+		opts, err := group(m.Or)
+		return Or(opts...), err
+	case m.Not != nil:
+		opts, err := group(m.Not)
+		return Not(opts...), err
+	}
+	return parseComparison(m, allow)
+}
+
+func parseComparison(m jsonMatch, allow AllowList) (MatchOption, error) {
+	var op Operator
+	found := false
+	for o, name := range filterOperators {
+		if name == m.Op {
+			op, found = o, true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("%w: unknown operator [%s]", ErrInvalidFilter, m.Op)
+	}
+	if err := allow.check(m.Field, op); err != nil {
+		return nil, err
+	}
+	value := jsonValue(m.Value)
+	invalid := func(expected string) error {
+		return fmt.Errorf("%w: %s on field [%s] requires %s, got %v", ErrInvalidFilter, m.Op, m.Field, expected, m.Value)
+	}
+	rv := reflect.ValueOf(value)
+	switch op {
+	case NULL, NOTNULL:
+		if value != nil {
+			return nil, invalid("no value")
+		}
+	case IN, NOTIN:
+		if rv.Kind() != reflect.Slice {
+			return nil, invalid("an array")
+		}
+	case BETWEEN, NOTBETWEEN:
+		if rv.Kind() != reflect.Slice || rv.Len() != 2 {
+			return nil, invalid("an array of two bounds")
+		}
+	case LIKE, NOTLIKE, ILIKE, REGEXP:
+		if rv.Kind() != reflect.String {
+			return nil, invalid("a string")
+		}
+	default:
+		if value == nil || rv.Kind() == reflect.Slice || rv.Kind() == reflect.Map {
+			return nil, invalid("a scalar")
+		}
+	}
+	if rv.Kind() == reflect.Slice {
+		for _, v := range value.([]any) {
+			if k := reflect.ValueOf(v).Kind(); v == nil || k == reflect.Slice || k == reflect.Map {
+				return nil, invalid("scalars in the array")
+			}
+		}
+	}
+	name := m.Field
+	return func(opts *MatchOptions, schema Schema) {
+		opts.oper(schema.Field(name), op, value)
+	}, nil
+}
+
+// parseSort accept the sorts like "age desc", the field of each one must be allowed
+func parseSort(sorts []string, allow AllowList) (MatchOption, error) {
+	type key struct {
+		field string
+		dir   string
+	}
+	var keys []key
+	for _, s := range sorts {
+		parts := strings.Fields(s)
+		if len(parts) == 0 || len(parts) > 2 {
+			return nil, fmt.Errorf("%w: sort [%s]", ErrInvalidFilter, s)
+		}
+		k := key{field: parts[0]}
+		if len(parts) == 2 {
+			k.dir = strings.ToUpper(parts[1])
+			if k.dir != "ASC" && k.dir != "DESC" {
+				return nil, fmt.Errorf("%w: sort direction [%s]", ErrInvalidFilter, parts[1])
+			}
+		}
+		if _, ok := allow[k.field]; !ok {
+			return nil, fmt.Errorf("%w: sort on field [%s] is not allowed", ErrInvalidFilter, k.field)
+		}
+		keys = append(keys, k)
+	}
+	return func(opts *MatchOptions, schema Schema) {
+		sort := make([]string, len(keys))
+		for i, k := range keys {
+			sort[i] = strings.TrimSpace(schema.Field(k.field) + " " + k.dir)
+		}
+		opts.SetSort(sort...)
+	}, nil
+}
+
+// jsonValue turn the json numbers into int64 or float64
+func jsonValue(v any) any {
+	switch vl := v.(type) {
+	case json.Number:
+		if i, err := vl.Int64(); err == nil {
+			return i
+		}
+		f, _ := vl.Float64()
+		return f
+	case []any:
+		ret := make([]any, len(vl))
+		for i, item := range vl {
+			ret[i] = jsonValue(item)
+		}
+		return ret
+	}
+	return v
+}
@@ -0,0 +1,115 @@
+package repository
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var memberFilters = AllowList{"name": {EQ, LIKE, IN}, "age": nil, "id": nil}
+
+func TestMarshalMatchOptions(t *testing.T) {
+	opts := []MatchOption{
+		func(opts *MatchOptions, schema Schema) {
+			opts.GTE(schema.Field("age"), 18).NotNull(schema.Field("name")).SetSort("age DESC", "id").SetLimit(10)
+		},
+		Or(
+			func(opts *MatchOptions, schema Schema) { opts.LIKE(schema.Field("name"), "a%") },
+			And(func(opts *MatchOptions, schema Schema) {
+				opts.Between(schema.Field("age"), 1, 5).IN(schema.Field("id"), []int{1, 2})
+			}),
+		),
+		Not(func(opts *MatchOptions, schema Schema) { opts.EQ(schema.Field("name"), "b") }),
+	}
+	data, err := MarshalMatchOptions(opts...)
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{
+		"match": [
+			{"field": "age", "op": "gte", "value": 18},
+			{"field": "name", "op": "notnull"},
+			{"or": [
+				{"field": "name", "op": "like", "value": "a%"},
+				{"and": [{"field": "age", "op": "between", "value": [1, 5]}, {"field": "id", "op": "in", "value": [1, 2]}]}
+			]},
+			{"not": [{"field": "name", "op": "eq", "value": "b"}]}
+		],
+		"sort": ["age DESC", "id"],
+		"limit": 10
+	}`, string(data))
+	parsed, err := ParseMatchOptions(data, AllowList{"name": nil, "age": nil, "id": nil})
+	assert.Nil(t, err)
+	assert.Equal(t, Sum(opts...), Sum(parsed...))
+}
+
+func TestMarshalMatchOptions_PositionalOR(t *testing.T) {
+	data, err := MarshalMatchOptions(func(opts *MatchOptions, schema Schema) {
+		opts.EQ("name", "a").EQ("age", 1).OR(func(opts *MatchOptions, schema Schema) { opts.EQ("name", "b") })
+	})
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"match": [{"or": [
+		{"and": [{"field": "name", "op": "eq", "value": "a"}, {"field": "age", "op": "eq", "value": 1}]},
+		{"field": "name", "op": "eq", "value": "b"}
+	]}]}`, string(data))
+	_, err = MarshalMatchOptions(func(opts *MatchOptions, schema Schema) { opts.Expr("age > ?", 1) })
+	assert.ErrorIs(t, err, ErrUnsupported)
+	_, err = MarshalMatchOptions(func(opts *MatchOptions, schema Schema) { opts.EQ("age", 1).SetCursor("", "id") })
+	assert.ErrorIs(t, err, ErrUnsupported)
+	_, err = MarshalMatchOptions(func(opts *MatchOptions, schema Schema) { opts.EQ("age", 1).WithTrashed() })
+	assert.ErrorIs(t, err, ErrUnsupported)
+}
+
+func TestAllowList_Regexp(t *testing.T) {
+	data := []byte(`{"match": [{"field": "name", "op": "regexp", "value": "^a"}]}`)
+	_, err := ParseMatchOptions(data, AllowList{"name": nil})
+	assert.ErrorIs(t, err, ErrInvalidFilter)
+	_, err = ParseMatchOptions(data, AllowList{"name": {EQ, REGEXP}})
+	assert.Nil(t, err)
+}
+
+func TestParseMatchOptions_Invalid(t *testing.T) {
+	for _, data := range []string{
+		`{"match": [{"field": "nick", "op": "eq", "value": "a"}]}`,
+		`{"match": [{"field": "name", "op": "gt", "value": "a"}]}`,
+		`{"match": [{"field": "name", "op": "expr", "value": "1 = 1"}]}`,
+		`{"match": [{"field": "name", "op": "eq", "value": ["a"]}]}`,
+		`{"match": [{"field": "age", "op": "between", "value": [1]}]}`,
+		`{"match": [{"field": "age", "op": "null", "value": 1}]}`,
+		`{"match": [{"field": "age", "op": "regexp", "value": "^(1+)+$"}]}`,
+		`{"match": [{"field": "name", "op": "eq", "value": "a", "or": []}]}`,
+		`{"match": [{"not": [{"not": [{"not": [{"not": [{"not": [{"not": [{"not": [{"not": [{"not": []}]}]}]}]}]}]}]}]}]}`,
+		`{"sort": ["nick"]}`,
+		`{"sort": ["age; DROP TABLE members"]}`,
+		`{"limit": -1}`,
+		`{"where": "1 = 1"}`,
+	} {
+		_, err := ParseMatchOptions([]byte(data), memberFilters)
+		assert.ErrorIs(t, err, ErrInvalidFilter, data)
+	}
+}
+
+func testParseMatchOptions(t *testing.T, repo RDBRepository) {
+	ctx := context.Background()
+	assert.Nil(t, repo.Create(ctx, []*Member{{Name: "a", Age: 10}, {Name: "b", Age: 20}, {Name: "c", Age: 30}, {Name: "ab", Age: 40}}))
+	opts, err := ParseMatchOptions([]byte(`{
+		"match": [{"or": [{"field": "name", "op": "like", "value": "a%"}, {"field": "age", "op": "between", "value": [20, 25]}]}],
+		"sort": ["age desc"],
+		"limit": 2
+	}`), memberFilters)
+	assert.Nil(t, err)
+	var members []Member
+	assert.Nil(t, repo.Find(ctx, &members, opts...))
+	var names []string
+	for _, m := range members {
+		names = append(names, m.Name)
+	}
+	assert.Equal(t, []string{"ab", "b"}, names)
+}
+
+func TestParseMatchOptions(t *testing.T) {
+	testParseMatchOptions(t, New(sqliteDB(t, &Member{}), &Member{}))
+}
+
+func TestMemoryRepository_ParseMatchOptions(t *testing.T) {
+	testParseMatchOptions(t, NewMemory(&Member{}))
+}
@@ -11,7 +11,7 @@ require (
 	github.com/yang-zzhong/xl v0.0.0-20230306140225-7a607948c6e0
 	gorm.io/driver/mysql v1.4.7
 	gorm.io/driver/sqlite v1.5.4
-	gorm.io/gorm v1.25.2-0.20230530020048-26663ab9bf55
+	gorm.io/gorm v1.25.5
 )
 
 require (
@@ -1,10 +1,19 @@
 package repository
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/dev-mockingbird/logf"
+	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
+	"gorm.io/gorm/utils"
 )
 
 type gormLogWriter struct {
@@ -37,3 +46,245 @@ func DefaultWriter(logger *log.Logger, callerDepth ...int) LogWriter {
 	}
 	return &gormLogWriter{logger: logger, callerDepth: depth}
 }
+
+// Redacted replace the params of the redacted columns in the logged sql
+const Redacted = "[REDACTED]"
+
+type gormLogger struct {
+	logger               logf.Logfer
+	level                logger.LogLevel
+	slowThreshold        time.Duration
+	ignoreRecordNotFound bool
+	redactParams         bool
+	redactColumns        map[string]bool
+}
+
+var _ logger.Interface = &gormLogger{}
+var _ gorm.ParamsFilter = &gormLogger{}
+
+type GormLogOption func(l *gormLogger)
+
+// WithLogLevel filter the logs before they reach the logf.Logfer, logger.Info by default so the
+// level of the logf.Logfer decides
+func WithLogLevel(level logger.LogLevel) GormLogOption {
+	return func(l *gormLogger) {
+		l.level = level
+	}
+}
+
+// WithSlowThreshold log the sql slower than threshold at Warn, 0 disables it
+func WithSlowThreshold(threshold time.Duration) GormLogOption {
+	return func(l *gormLogger) {
+		l.slowThreshold = threshold
+	}
+}
+
+// WithoutRecordNotFound don't log ErrRecordNotFound as an error
+func WithoutRecordNotFound() GormLogOption {
+	return func(l *gormLogger) {
+		l.ignoreRecordNotFound = true
+	}
+}
+
+// WithRedactedColumns log Redacted instead of the params compared with or written into the columns,
+// the columns are matched case insensitively without the table. it's best effort: the column of
+// a param is guessed from the sql text around its placeholder, the params of the expressions, the
+// subqueries and the syntax of the other dialects may be logged as they are, use WithRedactedParams
+// where nothing sensitive may be logged
+func WithRedactedColumns(columns ...string) GormLogOption {
+	return func(l *gormLogger) {
+		if l.redactColumns == nil {
+			l.redactColumns = make(map[string]bool)
+		}
+		for _, column := range columns {
+			l.redactColumns[strings.ToLower(column)] = true
+		}
+	}
+}
+
+// WithRedactedParams log the sql with the placeholders instead of any of the params
+func WithRedactedParams() GormLogOption {
+	return func(l *gormLogger) {
+		l.redactParams = true
+	}
+}
+
+// NewGormLogger log the sql of gorm through l, the sql is logged at Debug, the slow sql at Warn
+// and the failed at Error. the params are logged unless WithRedactedParams or WithRedactedColumns
+// usage:
+//
+//	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{
+//		Logger: NewGormLogger(logf.New(), WithSlowThreshold(200*time.Millisecond), WithRedactedColumns("password")),
+//	})
+func NewGormLogger(l logf.Logfer, opts ...GormLogOption) logger.Interface {
+	ret := &gormLogger{logger: l, level: logger.Info}
+	for _, apply := range opts {
+		apply(ret)
+	}
+	return ret
+}
+
+func (l *gormLogger) LogMode(level logger.LogLevel) logger.Interface {
+	ret := *l
+	ret.level = level
+	return &ret
+}
+
+func (l *gormLogger) Info(ctx context.Context, msg string, data ...any) {
+	if l.level >= logger.Info {
+		l.logger.Logf(logf.Info, "%s "+msg, append([]any{utils.FileWithLineNum()}, data...)...)
+	}
+}
+
+func (l *gormLogger) Warn(ctx context.Context, msg string, data ...any) {
+	if l.level >= logger.Warn {
+		l.logger.Logf(logf.Warn, "%s "+msg, append([]any{utils.FileWithLineNum()}, data...)...)
+	}
+}
+
+func (l *gormLogger) Error(ctx context.Context, msg string, data ...any) {
+	if l.level >= logger.Error {
+		l.logger.Logf(logf.Error, "%s "+msg, append([]any{utils.FileWithLineNum()}, data...)...)
+	}
+}
+
+func (l *gormLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	if l.level <= logger.Silent {
+		return
+	}
+	elapsed := time.Since(begin)
+	ms := float64(elapsed.Nanoseconds()) / 1e6
+	switch {
+	case err != nil && l.level >= logger.Error && !(l.ignoreRecordNotFound && errors.Is(err, gorm.ErrRecordNotFound)):
+		sql, rows := fc()
+		l.logger.Logf(logf.Error, "%s %s [%.3fms] [rows:%s] %s", utils.FileWithLineNum(), err, ms, traceRows(rows), sql)
+	case l.slowThreshold > 0 && elapsed > l.slowThreshold && l.level >= logger.Warn:
+		sql, rows := fc()
+		l.logger.Logf(logf.Warn, "%s SLOW SQL >= %v [%.3fms] [rows:%s] %s", utils.FileWithLineNum(), l.slowThreshold, ms, traceRows(rows), sql)
+	case l.level >= logger.Info:
+		sql, rows := fc()
+		l.logger.Logf(logf.Debug, "%s [%.3fms] [rows:%s] %s", utils.FileWithLineNum(), ms, traceRows(rows), sql)
+	}
+}
+
+// ParamsFilter redact the params before gorm explains the sql for the log
+func (l *gormLogger) ParamsFilter(ctx context.Context, sql string, params ...any) (string, []any) {
+	if l.redactParams {
+		return sql, nil
+	}
+	if len(l.redactColumns) == 0 || len(params) == 0 {
+		return sql, params
+	}
+	ret := append([]any{}, params...)
+	for i, column := range paramColumns(sql, len(params)) {
+		if l.redactColumns[strings.ToLower(column)] {
+			ret[i] = Redacted
+		}
+	}
+	return sql, ret
+}
+
+func traceRows(rows int64) string {
+	if rows == -1 {
+		return "-"
+	}
+	return strconv.FormatInt(rows, 10)
+}
+
+var (
+	insertColumns  = regexp.MustCompile(`(?is)^\s*INSERT\s+INTO\s+[^(]+\(([^)]*)\)\s*VALUES\s*`)
+	comparedColumn = regexp.MustCompile("(?is)(\\w+)[`\"\\]]?\\s*(?:=|<>|!=|<=|>=|<|>|\\b(?:LIKE|ILIKE|REGEXP|IN|BETWEEN))\\s*\\(?\\s*$")
+	continuedParam = regexp.MustCompile(`(?is)^\s*(?:,|AND)\s*$`)
+)
+
+// paramColumns guess the column each of the n params is compared with or inserted into by the
+// placeholders of the sql, the params of the expressions are left unknown
+func paramColumns(sql string, n int) []string {
+	columns := make([]string, n)
+	var inserted []string
+	values := len(sql)
+	if m := insertColumns.FindStringSubmatchIndex(sql); m != nil {
+		for _, column := range strings.Split(sql[m[2]:m[3]], ",") {
+			inserted = append(inserted, strings.Trim(column, " `\"[]"))
+		}
+		values = m[1]
+	}
+	ordinal, depth, position := 0, 0, 0
+	last, lastEnd := "", -1
+	for i := 0; i < len(sql); i++ {
+		c := sql[i]
+		if c == '\'' || c == '"' || c == '`' {
+			if j := strings.IndexByte(sql[i+1:], c); j >= 0 {
+				i += j + 1
+				continue
+			}
+			break
+		}
+		if i >= values {
+			switch {
+			case c == '(':
+				if depth == 0 {
+					position = 0
+				}
+				depth++
+			case c == ')':
+				depth--
+			case c == ',' && depth == 1:
+				position++
+			case depth == 0 && c != ',' && c != ' ' && c != '\n' && c != '\t':
+				values = len(sql)
+			}
+		}
+		index, width := placeholder(sql, i, &ordinal)
+		if width == 0 {
+			continue
+		}
+		var column string
+		switch {
+		case i >= values:
+			if position < len(inserted) {
+				column = inserted[position]
+			}
+		case lastEnd >= 0 && continuedParam.MatchString(sql[lastEnd:i]):
+			column = last
+		default:
+			from := i - 128
+			if from < 0 {
+				from = 0
+			}
+			if m := comparedColumn.FindStringSubmatch(sql[from:i]); m != nil {
+				column = m[1]
+			}
+		}
+		if index >= 0 && index < n {
+			columns[index] = column
+		}
+		last, lastEnd = column, i+width
+		i += width - 1
+	}
+	return columns
+}
+
+// placeholder detect the placeholder at i, ? of mysql and sqlite, $n of postgres and @pn of sqlserver
+func placeholder(sql string, i int, ordinal *int) (index, width int) {
+	switch {
+	case sql[i] == '?':
+		*ordinal++
+		return *ordinal - 1, 1
+	case sql[i] == '$':
+		width = 1
+	case sql[i] == '@' && i+1 < len(sql) && sql[i+1] == 'p':
+		width = 2
+	default:
+		return -1, 0
+	}
+	j := i + width
+	for j < len(sql) && sql[j] >= '0' && sql[j] <= '9' {
+		j++
+	}
+	if j == i+width {
+		return -1, 0
+	}
+	n, _ := strconv.Atoi(sql[i+width : j])
+	return n - 1, j - i
+}
@@ -0,0 +1,110 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dev-mockingbird/logf"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type logRecord struct {
+	level logf.Level
+	msg   string
+}
+
+type logRecorder struct {
+	records []logRecord
+}
+
+func (r *logRecorder) Logf(level logf.Level, format string, v ...any) {
+	r.records = append(r.records, logRecord{level: level, msg: fmt.Sprintf(format, v...)})
+}
+
+func (r *logRecorder) last() logRecord {
+	if len(r.records) == 0 {
+		return logRecord{}
+	}
+	return r.records[len(r.records)-1]
+}
+
+func TestGormLogger(t *testing.T) {
+	rec := &logRecorder{}
+	db := sqliteDB(t, &Member{}).Session(&gorm.Session{Logger: NewGormLogger(rec, WithRedactedColumns("Name"))})
+	repo := New(db, &Member{})
+	ctx := context.Background()
+	assert.Nil(t, repo.Create(ctx, []*Member{{Name: "secret", Age: 10}, {Name: "hidden", Age: 12}}))
+	assert.Equal(t, logf.Debug, rec.last().level)
+	assert.Contains(t, rec.last().msg, "[rows:2]")
+	assert.Contains(t, rec.last().msg, "[REDACTED]")
+	assert.NotContains(t, rec.last().msg, "secret")
+	assert.Contains(t, rec.last().msg, ",10,NULL)")
+
+	var members []Member
+	assert.Nil(t, repo.Find(ctx, &members, func(opts *MatchOptions, schema Schema) {
+		opts.IN(schema.Field("name"), []string{"secret", "hidden"})
+		opts.Between(schema.Field("age"), 1, 20)
+	}))
+	assert.NotContains(t, rec.last().msg, "secret")
+	assert.NotContains(t, rec.last().msg, "hidden")
+	assert.Contains(t, rec.last().msg, "BETWEEN 1 AND 20")
+
+	var member Member
+	assert.ErrorIs(t, repo.First(ctx, &member, func(opts *MatchOptions, schema Schema) {
+		opts.EQ(schema.Field("name"), "nobody")
+	}), ErrRecordNotFound)
+	assert.Equal(t, logf.Error, rec.last().level)
+	assert.Contains(t, rec.last().msg, "record not found")
+	assert.NotContains(t, rec.last().msg, "nobody")
+}
+
+func TestGormLogger_Levels(t *testing.T) {
+	rec := &logRecorder{}
+	l := NewGormLogger(rec, WithoutRecordNotFound(), WithSlowThreshold(time.Millisecond))
+	ctx := context.Background()
+	sql := func() (string, int64) { return "SELECT 1", -1 }
+
+	l.Trace(ctx, time.Now(), sql, gorm.ErrRecordNotFound)
+	assert.Equal(t, logf.Debug, rec.last().level)
+	l.Trace(ctx, time.Now().Add(-time.Second), sql, nil)
+	assert.Equal(t, logf.Warn, rec.last().level)
+	assert.True(t, strings.Contains(rec.last().msg, "SLOW SQL >= 1ms") && strings.HasSuffix(rec.last().msg, "[rows:-] SELECT 1"))
+	l.Trace(ctx, time.Now(), sql, gorm.ErrInvalidData)
+	assert.Equal(t, logf.Error, rec.last().level)
+
+	rec.records = nil
+	warn := l.LogMode(logger.Warn)
+	warn.Trace(ctx, time.Now(), sql, nil)
+	warn.Info(ctx, "info %d", 1)
+	assert.Equal(t, 0, len(rec.records))
+	warn.Warn(ctx, "warn %d", 1)
+	assert.Equal(t, logf.Warn, rec.last().level)
+	assert.True(t, strings.HasSuffix(rec.last().msg, " warn 1"))
+	l.LogMode(logger.Silent).Trace(ctx, time.Now(), sql, gorm.ErrInvalidData)
+	assert.Equal(t, 1, len(rec.records))
+}
+
+func TestGormLogger_ParamsFilter(t *testing.T) {
+	l := NewGormLogger(nil, WithRedactedColumns("password", "token")).(gorm.ParamsFilter)
+	for _, c := range []struct {
+		sql    string
+		params []any
+		expect []any
+	}{
+		{"INSERT INTO `users` (`name`,`password`) VALUES (?,?),(?,?) ON DUPLICATE KEY UPDATE `password`=VALUES(`password`)", []any{"a", "p1", "b", "p2"}, []any{"a", Redacted, "b", Redacted}},
+		{"UPDATE `users` SET `password`=?,`age`=? WHERE `users`.`token` IN (?,?) AND name = 'pass?word'", []any{"p", 1, "t1", "t2"}, []any{Redacted, 1, Redacted, Redacted}},
+		{`SELECT * FROM "users" WHERE "users"."password" = $2 AND "age" > $1`, []any{1, "p"}, []any{1, Redacted}},
+		{"SELECT * FROM users WHERE token LIKE ? OR COALESCE(age, ?) > 1", []any{"t%", 0}, []any{Redacted, 0}},
+	} {
+		_, params := l.ParamsFilter(context.Background(), c.sql, c.params...)
+		assert.Equal(t, c.expect, params, c.sql)
+	}
+	sql, params := NewGormLogger(nil, WithRedactedParams()).(gorm.ParamsFilter).ParamsFilter(context.Background(), "SELECT ?", 1)
+	assert.Equal(t, "SELECT ?", sql)
+	assert.Nil(t, params)
+}
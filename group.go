@@ -0,0 +1,141 @@
+// Copyright (c) 2024 Yang,Zhong
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+package repository
+
+import (
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// GroupBy group by the names, Fields and Funcs, the names are qualified through the Schema
+// usage:
+//
+//	var report []struct {
+//		AuthorID string
+//		Year     int
+//		Total    int64
+//	}
+//	year := Func{Template: "YEAR(%s)", Field: []any{Field("created_at")}}
+//	model := GetModel(&report, &Book{}).
+//		Fields("author_id", Field(year).AS("year"), Field(Count("id")).AS("total")).
+//		GroupBy("author_id", year).
+//		Rollup()
+//	err := repo.Find(ctx, model, func(opts *MatchOptions, schema Schema) {
+//		opts.OrderBy(Field("total").DESC())
+//	})
+func (m *Model) GroupBy(exprs ...any) *Model {
+	if m.Grp == nil {
+		m.Grp = &Group{}
+	}
+	m.Grp.Exprs = append(m.Grp.Exprs, exprs...)
+	return m
+}
+
+// Having match the groups
+func (m *Model) Having(having ...MatchOption) *Model {
+	if m.Grp == nil {
+		m.Grp = &Group{}
+	}
+	m.Grp.Having = append(m.Grp.Having, having...)
+	return m
+}
+
+// Rollup add the super aggregate rows of the groups, WITH ROLLUP on mysql and ROLLUP(...) on
+// postgres and sqlserver, the other dialects report ErrUnsupported. the group columns of the super
+// aggregate rows are NULL
+func (m *Model) Rollup() *Model {
+	if m.Grp == nil {
+		m.Grp = &Group{}
+	}
+	m.Grp.Rollup = true
+	return m
+}
+
+// OrderBy order by the names, Fields and Funcs after the sort, the names are quoted through
+// the Schema without the table, so the aliases of the aggregates can be ordered by
+func (opts *MatchOptions) OrderBy(exprs ...any) *MatchOptions {
+	opts.Order = append(opts.Order, exprs...)
+	return opts
+}
+
+func (repo *dbrepo) groupBy(model *gorm.DB, g *Group) {
+	schema := repo.schema()
+	var columns []string
+	if g.By != "" {
+		columns = append(columns, g.By)
+	}
+	for _, expr := range g.Exprs {
+		str, values, err := repo.compileExpression(schema, expr, schema.Field)
+		if err != nil {
+			model.AddError(err)
+			return
+		}
+		if len(values) > 0 {
+			model.AddError(fmt.Errorf("%w: bound values in group by", ErrUnsupported))
+			return
+		}
+		columns = append(columns, str)
+	}
+	by := strings.Join(columns, ",")
+	if g.Rollup {
+		switch dialect := repo.dialect(); dialect {
+		case "mysql":
+			by += " WITH ROLLUP"
+		case "postgres", "sqlserver":
+			by = "ROLLUP(" + by + ")"
+		default:
+			model.AddError(fmt.Errorf("%w: rollup on %s", ErrUnsupported, dialect))
+			return
+		}
+	}
+	if by != "" {
+		model.Group(by)
+	}
+	if g.Having != nil {
+		condi, values, err := repo.compileMatchOptions(schema, g.Having)
+		if err != nil {
+			model.AddError(err)
+			return
+		}
+		model.Having(condi, values...)
+	}
+}
+
+// orderBy order by the sorts and then the expressions, the values of the expressions are bound
+func (repo *dbrepo) orderBy(db *gorm.DB, sorts []string, exprs []any) {
+	schema := repo.schema()
+	columns := append([]string{}, sorts...)
+	var values []any
+	for _, expr := range exprs {
+		str, vs, err := repo.compileExpression(schema, expr, schema.Quote)
+		if err != nil {
+			db.AddError(err)
+			return
+		}
+		columns = append(columns, str)
+		values = append(values, vs...)
+	}
+	if len(values) == 0 {
+		db.Order(strings.Join(columns, ","))
+		return
+	}
+	db.Clauses(clause.OrderBy{Expression: clause.Expr{SQL: strings.Join(columns, ","), Vars: values}})
+}
+
+// compileExpression compile the Field or the Func, the name is turned by name
+func (repo *dbrepo) compileExpression(schema Schema, expr any, name func(string) string) (string, []any, error) {
+	switch vl := expr.(type) {
+	case string:
+		return name(vl), nil, nil
+	case field:
+		return repo.compileField(schema, vl)
+	case Func:
+		return repo.compileFunc(schema, vl)
+	}
+	return "", nil, fmt.Errorf("expression %T, requires a name, Field or Func", expr)
+}
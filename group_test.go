@@ -0,0 +1,95 @@
+package repository
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+type regionReport struct {
+	Region string
+	Status string
+	Total  int64
+}
+
+func TestGormRepository_GroupBy(t *testing.T) {
+	db, mock, err := sqlmock.New() // mock sql.DB
+	assert.Nil(t, err)
+	defer db.Close()
+	defer assert.Nil(t, mock.ExpectationsWereMet())
+	gdb, err := gorm.Open(dialector(db)) // open gorm db
+	assert.Nil(t, err)
+	repo := New(gdb, &Book{})
+	func() {
+		execSql := "^SELECT `author_id`,UPPER\\(`name`\\) AS name,COUNT\\(id\\) AS total FROM `books` GROUP BY `books`.`author_id`,UPPER\\(`name`\\) WITH ROLLUP HAVING COUNT\\(id\\) > \\? ORDER BY `total` DESC,`author_id`$"
+		mock.ExpectQuery(execSql).
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"author_id", "name", "total"}))
+	}()
+	upper := Func{Template: "UPPER(%s)", Field: []any{Field("name")}}
+	var report []struct {
+		AuthorID string
+		Name     string
+		Total    int64
+	}
+	model := GetModel(&report, &Book{}).
+		Fields(Field("author_id"), Field(upper).AS("name"), Field(Count("id")).AS("total")).
+		GroupBy("author_id", upper).
+		Having(func(opts *MatchOptions, schema Schema) { opts.GT("COUNT(id)", 1) }).
+		Rollup()
+	err = repo.Find(context.Background(), model, func(opts *MatchOptions, schema Schema) {
+		opts.OrderBy(Field("total").DESC(), "author_id")
+	})
+	assert.Nil(t, err)
+}
+
+func TestGroupBy(t *testing.T) {
+	repo := New(sqliteDB(t, &Sale{}), &Sale{})
+	ctx := context.Background()
+	assert.Nil(t, repo.Create(ctx, []*Sale{
+		{Region: "east", Amount: 10, Status: "paid"},
+		{Region: "east", Amount: 20, Status: "paid"},
+		{Region: "east", Amount: 5, Status: "open"},
+		{Region: "west", Amount: 40, Status: "paid"},
+	}))
+	var report []regionReport
+	model := GetModel(&report, &Sale{}).
+		Fields(Field("region"), Field("status"), Field(SUM("amount")).AS("total")).
+		GroupBy("region", "status")
+	assert.Nil(t, repo.Find(ctx, model, func(opts *MatchOptions, schema Schema) {
+		opts.OrderBy(Field("total").DESC())
+	}))
+	assert.Equal(t, []regionReport{
+		{Region: "west", Status: "paid", Total: 40},
+		{Region: "east", Status: "paid", Total: 30},
+		{Region: "east", Status: "open", Total: 5},
+	}, report)
+
+	// the bound values of the functions are kept in order
+	report = nil
+	paidFirst := Case().When(0, func(opts *MatchOptions, schema Schema) { opts.EQ(schema.Field("status"), "paid") }).Else(1)
+	assert.Nil(t, repo.Find(ctx, model, func(opts *MatchOptions, schema Schema) {
+		opts.SetSort("region").OrderBy(Func{Template: "MIN(%s)", Field: []any{paidFirst}}, Field("total").ASC())
+	}))
+	assert.Equal(t, []regionReport{
+		{Region: "east", Status: "paid", Total: 30},
+		{Region: "east", Status: "open", Total: 5},
+		{Region: "west", Status: "paid", Total: 40},
+	}, report)
+
+	err := repo.Find(ctx, GetModel(&report, &Sale{}).
+		Fields(Field("region"), Field(SUM("amount")).AS("total")).GroupBy("region").Rollup())
+	assert.ErrorIs(t, err, ErrUnsupported)
+}
+
+func TestOrderBySum(t *testing.T) {
+	desc := func(opts *MatchOptions, schema Schema) { opts.OrderBy(Field("total").DESC()) }
+	asc := func(opts *MatchOptions, schema Schema) { opts.OrderBy(Field("total").ASC()) }
+	assert.NotEqual(t, Sum(desc), Sum(asc))
+	assert.NotEqual(t, Sum(desc), Sum())
+	_, err := MarshalMatchOptions(desc)
+	assert.ErrorIs(t, err, ErrUnsupported)
+}
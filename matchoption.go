@@ -5,10 +5,15 @@
 package repository
 
 import (
+	"bytes"
 	"crypto/md5"
+	"database/sql/driver"
 	"encoding/hex"
 	"fmt"
-	"strings"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
 )
 
 type MatchOptions struct {
@@ -16,26 +21,172 @@ type MatchOptions struct {
 	Sort    []string
 	Limit   *int
 	Offset  *int
-	schema  Schema
+	Cursor  *Cursor
+	// Trashed is the scope of the soft deleted records, see WithTrashed, OnlyTrashed and DeletedAfter
+	Trashed      Trashed
+	DeletedSince *time.Time
+	// Order is the names, Fields and Funcs ordered by after Sort, see OrderBy
+	Order  []any
+	schema Schema
 }
 
+// Sum hash the canonical encoding of the options, nested options are resolved
+// against the schema, so equal options produce the same sum across builds
 func (opts MatchOptions) Sum() string {
-	str := ""
+	sum := md5.Sum(opts.canonical())
+	return hex.EncodeToString(sum[:])
+}
+
+// Equal report whether the options are structurally identical
+func (opts MatchOptions) Equal(other MatchOptions) bool {
+	return bytes.Equal(opts.canonical(), other.canonical())
+}
+
+func (opts MatchOptions) canonical() []byte {
+	var buf bytes.Buffer
+	opts.encode(&buf)
+	return buf.Bytes()
+}
+
+func (opts MatchOptions) encode(w *bytes.Buffer) {
+	fmt.Fprintf(w, "m%d:", len(opts.Matches))
 	for _, m := range opts.Matches {
-		str += fmt.Sprintf("%s%d%v", m.Field, m.Operator, m.Value)
+		encodeString(w, m.Field)
+		fmt.Fprintf(w, "o%d:", m.Operator)
+		opts.encodeValue(w, m.Value)
+	}
+	fmt.Fprintf(w, "s%d:", len(opts.Sort))
+	for _, s := range opts.Sort {
+		encodeString(w, s)
+	}
+	encodeIntPtr(w, opts.Limit)
+	encodeIntPtr(w, opts.Offset)
+	// nothing is written for the default cursor and trashed scope to keep the sums of the existing options
+	if opts.Cursor != nil {
+		fmt.Fprintf(w, "c%d:", len(opts.Cursor.Sort))
+		for _, s := range opts.Cursor.Sort {
+			encodeString(w, s)
+		}
+		encodeString(w, opts.Cursor.Token)
 	}
-	sum := md5.Sum([]byte(fmt.Sprintf("%s%s%d%d", str, strings.Join(opts.Sort, ""), func() int {
-		if opts.Limit != nil {
-			return *opts.Limit
+	if opts.Trashed != TrashedExcluded || opts.DeletedSince != nil {
+		fmt.Fprintf(w, "t%d:", opts.Trashed)
+		opts.encodeValue(w, opts.DeletedSince)
+	}
+	if len(opts.Order) > 0 {
+		fmt.Fprintf(w, "r%d:", len(opts.Order))
+		for _, o := range opts.Order {
+			opts.encodeValue(w, o)
 		}
-		return 0
-	}(), func() int {
-		if opts.Offset != nil {
-			return *opts.Offset
+	}
+}
+
+// encodeValue write a type tagged, length prefixed encoding of v
+func (opts MatchOptions) encodeValue(w *bytes.Buffer, v any) {
+	switch vl := v.(type) {
+	case nil:
+		w.WriteString("n")
+		return
+	case []MatchOption:
+		sub := MatchOptions{schema: opts.schema}
+		if sub.schema == nil {
+			sub.schema = plainSchema{}
 		}
-		return 0
-	}())))
-	return hex.EncodeToString(sum[:])
+		sub.Apply(vl...)
+		w.WriteString("O")
+		sub.encode(w)
+		return
+	case field:
+		w.WriteString("f")
+		opts.encodeValue(w, vl.Field)
+		encodeString(w, vl.as)
+		fmt.Fprintf(w, "%t%t", vl.asc, vl.desc)
+		return
+	case Func:
+		w.WriteString("F")
+		encodeString(w, vl.Template)
+		opts.encodeValue(w, vl.Field)
+		return
+	case time.Time:
+		w.WriteString("t")
+		encodeString(w, vl.UTC().Format(time.RFC3339Nano))
+		return
+	case []byte:
+		w.WriteString("b")
+		encodeString(w, hex.EncodeToString(vl))
+		return
+	case driver.Valuer:
+		if rv := reflect.ValueOf(v); rv.Kind() != reflect.Ptr || !rv.IsNil() {
+			if value, err := vl.Value(); err == nil {
+				w.WriteString("v")
+				opts.encodeValue(w, value)
+				return
+			}
+		}
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if rv.IsNil() {
+			w.WriteString("n")
+			return
+		}
+		opts.encodeValue(w, rv.Elem().Interface())
+	case reflect.String:
+		w.WriteString("s")
+		encodeString(w, rv.String())
+	case reflect.Bool:
+		fmt.Fprintf(w, "B%t", rv.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		encodeString(w, "i"+strconv.FormatInt(rv.Int(), 10))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		encodeString(w, "u"+strconv.FormatUint(rv.Uint(), 10))
+	case reflect.Float32, reflect.Float64:
+		encodeString(w, "d"+strconv.FormatFloat(rv.Float(), 'g', -1, 64))
+	case reflect.Slice, reflect.Array:
+		fmt.Fprintf(w, "l%d:", rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			opts.encodeValue(w, rv.Index(i).Interface())
+		}
+	case reflect.Map:
+		entries := make([]string, 0, rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			var entry bytes.Buffer
+			opts.encodeValue(&entry, iter.Key().Interface())
+			opts.encodeValue(&entry, iter.Value().Interface())
+			entries = append(entries, entry.String())
+		}
+		sort.Strings(entries)
+		fmt.Fprintf(w, "M%d:", len(entries))
+		for _, entry := range entries {
+			w.WriteString(entry)
+		}
+	case reflect.Struct:
+		fmt.Fprintf(w, "S%d:", rv.NumField())
+		encodeString(w, rv.Type().String())
+		for i := 0; i < rv.NumField(); i++ {
+			if rv.Type().Field(i).IsExported() {
+				opts.encodeValue(w, rv.Field(i).Interface())
+			}
+		}
+	default:
+		// functions and channels have no stable representation
+		w.WriteString("x")
+		encodeString(w, rv.Type().String())
+	}
+}
+
+func encodeString(w *bytes.Buffer, s string) {
+	fmt.Fprintf(w, "%d:%s", len(s), s)
+}
+
+func encodeIntPtr(w *bytes.Buffer, i *int) {
+	if i == nil {
+		w.WriteString("n")
+		return
+	}
+	encodeString(w, strconv.Itoa(*i))
 }
 
 type Schema interface {
@@ -43,6 +194,23 @@ type Schema interface {
 	Field(field string) string
 }
 
+// plainSchema qualify the field with the table without quoting, it's used where
+// no database is involved
+type plainSchema struct {
+	table string
+}
+
+func (ps plainSchema) Field(field string) string {
+	if ps.table == "" {
+		return field
+	}
+	return ps.table + "." + field
+}
+
+func (ps plainSchema) Quote(field string) string {
+	return field
+}
+
 type MatchOption func(opts *MatchOptions, schema Schema)
 
 func (opts *MatchOptions) oper(field string, oper Operator, val interface{}) *MatchOptions {
@@ -132,8 +300,23 @@ func (opts *MatchOptions) SetSort(sort ...string) *MatchOptions {
 	return opts
 }
 
+// SetCursor page the records by the sort keys instead of the offset, the sort replaces
+// the one of SetSort and its last key must be unique. token is the Next or Prev of the
+// CursorPage returned by FindPage, empty for the first page
+func (opts *MatchOptions) SetCursor(token string, sort ...string) *MatchOptions {
+	opts.Cursor = &Cursor{Token: token, Sort: sort}
+	return opts
+}
+
 func Sum(opts ...MatchOption) string {
-	var opt MatchOptions
+	opt := MatchOptions{schema: plainSchema{}}
 	opt.Apply(opts...)
 	return opt.Sum()
 }
+
+// Equal report whether the options of a and b are structurally identical
+func Equal(a, b []MatchOption) bool {
+	optA := MatchOptions{schema: plainSchema{}}
+	optB := MatchOptions{schema: plainSchema{}}
+	return optA.Apply(a...).Equal(optB.Apply(b...))
+}
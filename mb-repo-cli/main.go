@@ -163,7 +163,7 @@ import (
 
 type {{.Model}} struct {
 	Id 		  string __tag__json:"id" gorm:"primaryKey"__tag__
-	// DeletedAt soft delete, Repository.DeletedAfter will use this
+	// DeletedAt soft delete, MatchOptions.WithTrashed, OnlyTrashed and DeletedAfter scope the deleted records
 	DeletedAt gorm.DeletedAt
 }
 
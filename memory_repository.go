@@ -0,0 +1,1367 @@
+// Copyright (c) 2024 Yang,Zhong
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+package repository
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yang-zzhong/xl/utils"
+	"gorm.io/gorm"
+	gschema "gorm.io/gorm/schema"
+)
+
+type predicate func(row reflect.Value) (bool, error)
+
+type memrepo struct {
+	mu            sync.RWMutex
+	model         *gschema.Schema
+	table         string
+	tables        map[string][]reflect.Value
+	autoIncrement map[string]int64
+	softDelete    *gschema.Field
+	version       *gschema.Field
+	// err is the error of NewMemory, every call fails with it
+	err error
+}
+
+var _ RDBRepository = &memrepo{}
+
+// NewMemory create a repository which keeps the records of model in memory,
+// the match options are evaluated in go, so it's a drop-in replacement of the
+// db repository for unit tests. an invalid model or option fails every call of the repository.
+// usage:
+//
+//	repo := NewMemory(&Book{})
+//	err := repo.Create(ctx, []*Book{{ID: "1", Name: "hello"}})
+//	var books []Book
+//	err = repo.Find(ctx, &books, AuthorID([]string{"1", "2"}))
+func NewMemory(model any, opts ...Option) RDBRepository {
+	s, err := gschema.Parse(model, &sync.Map{}, gschema.NamingStrategy{})
+	if err != nil {
+		return &memrepo{err: fmt.Errorf("parse model %T: %w", model, err)}
+	}
+	var o options
+	for _, apply := range opts {
+		apply(&o)
+	}
+	repo := &memrepo{
+		model:         s,
+		table:         s.Table,
+		tables:        make(map[string][]reflect.Value),
+		autoIncrement: make(map[string]int64),
+		softDelete:    softDeleteField(s),
+		version:       versionField(s),
+	}
+	if o.softDelete != "" {
+		if repo.softDelete = s.LookUpField(o.softDelete); repo.softDelete == nil {
+			repo.err = fmt.Errorf("unknown soft delete field: %s", o.softDelete)
+		}
+	}
+	return repo
+}
+
+func (repo *memrepo) ModelName() string {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	return repo.table
+}
+
+func (repo *memrepo) SetTable(table string) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	repo.table = table
+}
+
+func (repo *memrepo) First(ctx context.Context, v any, opts ...MatchOption) error {
+	table := repo.ModelName()
+	rows, result, err := repo.query(ctx, table, v, true, opts...)
+	if err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		return ErrRecordNotFound
+	}
+	return repo.scan(table, result, rows[:1])
+}
+
+func (repo *memrepo) Find(ctx context.Context, v any, opts ...MatchOption) error {
+	table := repo.ModelName()
+	rows, result, err := repo.query(ctx, table, v, false, opts...)
+	if err != nil {
+		return err
+	}
+	return repo.scan(table, result, rows)
+}
+
+func (repo *memrepo) FindPage(ctx context.Context, v any, opts ...MatchOption) (CursorPage, error) {
+	table := repo.ModelName()
+	return findPage(ctx, repo.Find, gschema.NamingStrategy{}, repo.schema(table), v, opts)
+}
+
+func (repo *memrepo) Paginate(ctx context.Context, v any, page, size int, opts ...MatchOption) (Page, error) {
+	return paginate(ctx, func(ctx context.Context) (int64, error) {
+		var total int64
+		err := repo.Count(ctx, &total, append(append([]MatchOption{}, opts...), unpaged)...)
+		return total, err
+	}, repo.Find, v, page, size, opts)
+}
+
+func (repo *memrepo) Each(ctx context.Context, v any, fn func() error, opts ...MatchOption) error {
+	table := repo.ModelName()
+	rows, result, err := repo.query(ctx, table, v, false, opts...)
+	if err != nil {
+		return err
+	}
+	rv := reflect.ValueOf(result)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() == reflect.Slice {
+		return fmt.Errorf("each only support pointer of struct as result, got %T", result)
+	}
+	for i := range rows {
+		if err := contextError(ctx); err != nil {
+			return err
+		}
+		rv.Elem().Set(reflect.Zero(rv.Elem().Type()))
+		if err := repo.scan(table, result, rows[i:i+1]); err != nil {
+			return err
+		}
+		if err := fn(); err != nil {
+			if errors.Is(err, ErrStopIteration) {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func (repo *memrepo) FindInBatches(ctx context.Context, v any, size int, fn func() error, opts ...MatchOption) error {
+	table := repo.ModelName()
+	if size <= 0 {
+		return fmt.Errorf("find in batches requires a positive size, got %d", size)
+	}
+	rows, result, err := repo.query(ctx, table, v, false, opts...)
+	if err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	batch, err := newBatcher(reflect.ValueOf(result), size, fn)
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		if err = contextError(ctx); err != nil {
+			return err
+		}
+		elem := batch.elem()
+		if err = repo.assign(table, elem.Elem(), row.Elem()); err != nil {
+			return err
+		}
+		if err = batch.add(elem); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = batch.flush()
+	}
+	if errors.Is(err, ErrStopIteration) {
+		return nil
+	}
+	return err
+}
+
+func (repo *memrepo) Count(ctx context.Context, v any, opts ...MatchOption) error {
+	table := repo.ModelName()
+	result, err := repo.result(table, v)
+	if err != nil {
+		return err
+	}
+	count, ok := result.(*int64)
+	if !ok {
+		return fmt.Errorf("count only support *int64 as result")
+	}
+	rows, err := repo.filter(ctx, table, opts...)
+	if err != nil {
+		return err
+	}
+	*count = int64(len(rows))
+	return nil
+}
+
+func (repo *memrepo) Update(ctx context.Context, v any) error {
+	table := repo.ModelName()
+	if err := repo.ready(ctx); err != nil {
+		return err
+	}
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() != reflect.Struct || rv.Type() != repo.model.ModelType {
+		return fmt.Errorf("update only support %s as value", repo.model.ModelType)
+	}
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	if repo.zeroPrimaryKey(rv) {
+		return repo.insert(ctx, table, []reflect.Value{rv})
+	}
+	rows := repo.tables[table]
+	index := -1
+	for i, row := range rows {
+		if repo.samePrimaryKey(row.Elem(), rv) {
+			index = i
+			break
+		}
+	}
+	if repo.version != nil {
+		if !rv.CanAddr() {
+			return fmt.Errorf("update a versioned record requires a pointer, got %T", v)
+		}
+		if index < 0 {
+			return ErrStaleRecord
+		}
+		stored, err := versionOf(ctx, repo.version, rows[index].Elem())
+		if err != nil {
+			return err
+		}
+		version, err := versionOf(ctx, repo.version, rv)
+		if err != nil {
+			return err
+		}
+		if stored != version {
+			return ErrStaleRecord
+		}
+		if err := repo.version.Set(ctx, rv, version+1); err != nil {
+			return err
+		}
+	}
+	now := time.Now()
+	for _, f := range repo.model.Fields {
+		if f.AutoUpdateTime > 0 && rv.CanAddr() {
+			if err := f.Set(ctx, rv, now); err != nil {
+				return err
+			}
+		}
+	}
+	if index >= 0 {
+		rows[index] = repo.copy(rv)
+		return nil
+	}
+	repo.tables[table] = append(rows, repo.copy(rv))
+	return nil
+}
+
+func (repo *memrepo) Delete(ctx context.Context, opts ...MatchOption) error {
+	table := repo.ModelName()
+	if err := repo.ready(ctx); err != nil {
+		return err
+	}
+	if !hasMatches(repo.schema(table), opts) {
+		return gorm.ErrMissingWhereClause
+	}
+	if repo.softDelete != nil {
+		return repo.setDeleted(ctx, table, time.Now(), opts)
+	}
+	return repo.remove(ctx, table, opts)
+}
+
+// Restore clear the deleted_at of the soft deleted records
+func (repo *memrepo) Restore(ctx context.Context, opts ...MatchOption) error {
+	table := repo.ModelName()
+	if err := repo.ready(ctx); err != nil {
+		return err
+	}
+	if repo.softDelete == nil {
+		return fmt.Errorf("%w: restore the records of the repository which doesn't soft delete", ErrUnsupported)
+	}
+	return repo.setDeleted(ctx, table, nil, append([]MatchOption{onlyTrashed}, opts...))
+}
+
+// ForceDelete remove the records no matter they are soft deleted or not
+func (repo *memrepo) ForceDelete(ctx context.Context, opts ...MatchOption) error {
+	table := repo.ModelName()
+	if err := repo.ready(ctx); err != nil {
+		return err
+	}
+	return repo.remove(ctx, table, append([]MatchOption{withTrashed}, opts...))
+}
+
+// Aggregate is unsupported, the aggregates are sql functions
+func (repo *memrepo) Aggregate(ctx context.Context, model *Model, opts ...MatchOption) error {
+	return fmt.Errorf("%w: aggregate in memory repository", ErrUnsupported)
+}
+
+func (repo *memrepo) remove(ctx context.Context, table string, opts []MatchOption) error {
+	cond, err := repo.condition(table, opts)
+	if err != nil {
+		return err
+	}
+	if cond == nil {
+		return gorm.ErrMissingWhereClause
+	}
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	var kept []reflect.Value
+	for _, row := range repo.tables[table] {
+		ok, err := cond(row.Elem())
+		if err != nil {
+			return err
+		}
+		if !ok {
+			kept = append(kept, row)
+		}
+	}
+	repo.tables[table] = kept
+	return nil
+}
+
+// setDeleted set the soft delete field of the matched records to value
+func (repo *memrepo) setDeleted(ctx context.Context, table string, value any, opts []MatchOption) error {
+	cond, err := repo.condition(table, opts)
+	if err != nil {
+		return err
+	}
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	// the rows are updated in a copy of the table, which is stored only if all of them are updated
+	rows := append([]reflect.Value{}, repo.tables[table]...)
+	for i, row := range rows {
+		if cond != nil {
+			ok, err := cond(row.Elem())
+			if err != nil {
+				return err
+			}
+			if !ok {
+				continue
+			}
+		}
+		updated := repo.copy(row.Elem())
+		if err := repo.softDelete.Set(ctx, updated.Elem(), value); err != nil {
+			return err
+		}
+		rows[i] = updated
+	}
+	repo.tables[table] = rows
+	return nil
+}
+
+func (repo *memrepo) Create(ctx context.Context, v any) error {
+	table := repo.ModelName()
+	if err := repo.ready(ctx); err != nil {
+		return err
+	}
+	values, err := repo.values("create", v)
+	if err != nil {
+		return err
+	}
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	return repo.insert(ctx, table, values)
+}
+
+// CreateInBatches create the chunks one by one, each chunk is created atomically like Create,
+// the table is restored if a chunk fails with InTransaction
+func (repo *memrepo) CreateInBatches(ctx context.Context, v any, size int, opts ...BatchOption) error {
+	var o batchOptions
+	for _, apply := range opts {
+		apply(&o)
+	}
+	if !o.tx {
+		return createInBatches(ctx, v, size, false, repo.Create)
+	}
+	if err := repo.ready(ctx); err != nil {
+		return err
+	}
+	table := repo.ModelName()
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	rows, increment := repo.tables[table], repo.autoIncrement[table]
+	err := createInBatches(ctx, v, size, true, func(ctx context.Context, chunk any) error {
+		values, err := repo.values("create", chunk)
+		if err != nil {
+			return err
+		}
+		return repo.insert(ctx, table, values)
+	})
+	if err != nil {
+		repo.tables[table], repo.autoIncrement[table] = rows, increment
+	}
+	return err
+}
+
+// Upsert update the records whose conflict columns equal to the ones of v, the others are inserted,
+// nothing is changed if any of them fails
+func (repo *memrepo) Upsert(ctx context.Context, v any, conflictColumns, updateFields []string) (UpsertResult, error) {
+	table := repo.ModelName()
+	var res UpsertResult
+	if err := repo.ready(ctx); err != nil {
+		return res, err
+	}
+	values, err := repo.values("upsert", v)
+	if err != nil {
+		return res, err
+	}
+	var conflicts, updates []*gschema.Field
+	for _, column := range conflictColumns {
+		f, err := repo.lookup(table, column)
+		if err != nil {
+			return res, err
+		}
+		conflicts = append(conflicts, f)
+	}
+	if len(conflicts) == 0 {
+		conflicts = repo.model.PrimaryFields
+	}
+	for _, column := range updateFields {
+		f, err := repo.lookup(table, column)
+		if err != nil {
+			return res, err
+		}
+		updates = append(updates, f)
+	}
+	if len(updates) == 0 {
+		for _, f := range repo.model.Fields {
+			if !f.PrimaryKey && f.AutoCreateTime == 0 && f.DBName != "" {
+				updates = append(updates, f)
+			}
+		}
+	}
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	rows, increment := repo.tables[table], repo.autoIncrement[table]
+	repo.tables[table] = append([]reflect.Value{}, rows...)
+	defer func() {
+		if err != nil {
+			repo.tables[table], repo.autoIncrement[table] = rows, increment
+		}
+	}()
+	now := time.Now()
+	for _, value := range values {
+		var i int
+		if i, err = repo.conflicted(table, value, conflicts); err != nil {
+			return UpsertResult{}, err
+		}
+		if i < 0 {
+			if err = repo.insert(ctx, table, []reflect.Value{value}); err != nil {
+				return UpsertResult{}, err
+			}
+			res.Inserted++
+			continue
+		}
+		row := repo.copy(repo.tables[table][i].Elem())
+		for _, f := range updates {
+			fv, _ := f.ValueOf(ctx, value)
+			if f.AutoUpdateTime > 0 {
+				fv = now
+			}
+			if err = f.Set(ctx, row.Elem(), fv); err != nil {
+				return UpsertResult{}, err
+			}
+		}
+		repo.tables[table][i] = row
+		if value.CanSet() {
+			value.Set(row.Elem())
+		}
+		res.Updated++
+	}
+	res.Affected, res.Known = res.Inserted+res.Updated, true
+	return res, nil
+}
+
+// conflicted find the index of the row whose fields equal to the ones of value, -1 if no row is found
+func (repo *memrepo) conflicted(table string, value reflect.Value, fields []*gschema.Field) (int, error) {
+	if len(fields) == 0 {
+		return -1, nil
+	}
+	for i, row := range repo.tables[table] {
+		same := true
+		for _, f := range fields {
+			a, _ := f.ValueOf(context.Background(), row.Elem())
+			b, _ := f.ValueOf(context.Background(), value)
+			c, err := compareNullable(memValue(a), memValue(b))
+			if err != nil {
+				return -1, err
+			}
+			if same = c == 0; !same {
+				break
+			}
+		}
+		if same {
+			return i, nil
+		}
+	}
+	return -1, nil
+}
+
+// values get the records of v, v is a record or a slice of records
+func (repo *memrepo) values(op string, v any) ([]reflect.Value, error) {
+	var values []reflect.Value
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+		rv = rv.Elem()
+	}
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			values = append(values, reflect.Indirect(rv.Index(i)))
+		}
+	default:
+		values = append(values, rv)
+	}
+	for _, value := range values {
+		if value.Kind() != reflect.Struct || value.Type() != repo.model.ModelType {
+			return nil, fmt.Errorf("%s only support %s as value", op, repo.model.ModelType)
+		}
+	}
+	return values, nil
+}
+
+func (repo *memrepo) UpdateFields(ctx context.Context, fields Fields, opts ...MatchOption) error {
+	table := repo.ModelName()
+	if err := repo.ready(ctx); err != nil {
+		return err
+	}
+	if !hasMatches(repo.schema(table), opts) {
+		return gorm.ErrMissingWhereClause
+	}
+	var expected any
+	var versioned bool
+	if repo.version != nil {
+		fields, expected, versioned = expectedVersion(repo.version, fields)
+		if versioned {
+			opts = append(append([]MatchOption{}, opts...), func(opts *MatchOptions, schema Schema) {
+				opts.EQ(schema.Field(repo.version.DBName), expected)
+			})
+		}
+	}
+	cond, err := repo.condition(table, opts)
+	if err != nil {
+		return err
+	}
+	values := make(map[*gschema.Field]any)
+	for name, value := range fields {
+		f, err := repo.lookup(table, name)
+		if err != nil {
+			return err
+		}
+		values[f] = value
+	}
+	now := time.Now()
+	for _, f := range repo.model.Fields {
+		if _, ok := values[f]; !ok && f.AutoUpdateTime > 0 {
+			values[f] = now
+		}
+	}
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	// the rows are updated in a copy of the table, which is stored only if all of them are updated
+	rows := append([]reflect.Value{}, repo.tables[table]...)
+	matched := 0
+	for i, row := range rows {
+		ok, err := cond(row.Elem())
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		updated := repo.copy(row.Elem())
+		for f, value := range values {
+			if err := f.Set(ctx, updated.Elem(), value); err != nil {
+				return err
+			}
+		}
+		if repo.version != nil {
+			version, err := versionOf(ctx, repo.version, updated.Elem())
+			if err != nil {
+				return err
+			}
+			if err := repo.version.Set(ctx, updated.Elem(), version+1); err != nil {
+				return err
+			}
+		}
+		rows[i] = updated
+		matched++
+	}
+	if versioned && matched == 0 {
+		return ErrStaleRecord
+	}
+	repo.tables[table] = rows
+	return nil
+}
+
+// insert must be called with the lock held, the values are validated before any
+// of them is stored, so a failed insert leaves the table untouched
+func (repo *memrepo) insert(ctx context.Context, table string, values []reflect.Value) error {
+	now := time.Now()
+	rows := repo.tables[table]
+	increment := repo.autoIncrement[table]
+	var inserted []reflect.Value
+	for _, value := range values {
+		row := repo.copy(value)
+		for _, f := range repo.model.Fields {
+			if v, zero := f.ValueOf(ctx, row.Elem()); !zero {
+				if id, ok := memValue(v).(int64); ok && f.AutoIncrement && f.PrimaryKey && id > increment {
+					increment = id
+				}
+				continue
+			}
+			var err error
+			switch {
+			case f.AutoIncrement && f.PrimaryKey:
+				increment++
+				err = f.Set(ctx, row.Elem(), increment)
+			case f.AutoCreateTime > 0 || f.AutoUpdateTime > 0:
+				err = f.Set(ctx, row.Elem(), now)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		for _, existed := range rows {
+			if repo.samePrimaryKey(existed.Elem(), row.Elem()) {
+				return ErrDuplicatedKey
+			}
+		}
+		for _, existed := range inserted {
+			if repo.samePrimaryKey(existed.Elem(), row.Elem()) {
+				return ErrDuplicatedKey
+			}
+		}
+		inserted = append(inserted, row)
+	}
+	for i, row := range inserted {
+		if values[i].CanSet() {
+			values[i].Set(row.Elem())
+		}
+	}
+	repo.autoIncrement[table] = increment
+	repo.tables[table] = append(rows, inserted...)
+	return nil
+}
+
+func (repo *memrepo) query(ctx context.Context, table string, v any, first bool, opts ...MatchOption) ([]reflect.Value, any, error) {
+	result, err := repo.result(table, v)
+	if err != nil {
+		return nil, nil, err
+	}
+	rows, err := repo.filter(ctx, table, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	o := MatchOptions{schema: repo.schema(table)}
+	o.Apply(opts...)
+	if len(o.Order) > 0 {
+		return nil, nil, fmt.Errorf("%w: order by expressions in memory repository", ErrUnsupported)
+	}
+	sorts := o.Sort
+	if o.Cursor != nil {
+		if rows, sorts, err = repo.seek(table, rows, *o.Cursor); err != nil {
+			return nil, nil, err
+		}
+	}
+	if first && len(sorts) == 0 {
+		for _, f := range repo.model.PrimaryFields {
+			sorts = append(sorts, f.DBName)
+		}
+	}
+	if err := repo.sort(table, rows, sorts); err != nil {
+		return nil, nil, err
+	}
+	if o.Offset != nil {
+		if *o.Offset < len(rows) {
+			rows = rows[*o.Offset:]
+		} else {
+			rows = nil
+		}
+	}
+	if o.Limit != nil && *o.Limit >= 0 && *o.Limit < len(rows) {
+		rows = rows[:*o.Limit]
+	}
+	return rows, result, nil
+}
+
+// seek keep the rows after the position of the cursor, the sorts of the cursor are returned
+func (repo *memrepo) seek(table string, rows []reflect.Value, cursor Cursor) ([]reflect.Value, []string, error) {
+	_, values, sorts, err := cursor.compile(repo.schema(table), "")
+	if err != nil {
+		return nil, nil, err
+	}
+	token, _ := decodeCursor(cursor.Token)
+	if token.Values == nil {
+		return rows, sorts, nil
+	}
+	keys := cursor.keys()
+	fields := make([]*gschema.Field, len(keys))
+	for i, key := range keys {
+		if fields[i], err = repo.lookup(table, key.field); err != nil {
+			return nil, nil, err
+		}
+	}
+	values, _ = token.values(len(keys))
+	var kept []reflect.Value
+	for _, row := range rows {
+		for i, f := range fields {
+			v, _ := f.ValueOf(context.Background(), row.Elem())
+			c, err := compareNullable(memValue(v), memValue(values[i]))
+			if err != nil {
+				return nil, nil, err
+			}
+			if c == 0 {
+				continue
+			}
+			if (c > 0) != (keys[i].desc != token.Before) {
+				kept = append(kept, row)
+			}
+			break
+		}
+	}
+	return kept, sorts, nil
+}
+
+func (repo *memrepo) result(table string, v any) (any, error) {
+	m, ok := v.(*Model)
+	if !ok {
+		return v, nil
+	}
+	if len(m.Joins) > 0 || m.Grp != nil || len(m.Flds) > 0 {
+		return nil, fmt.Errorf("%w: joins, groups and fields of model in memory repository", ErrUnsupported)
+	}
+	if m.From != nil {
+		if from, ok := m.From.(string); ok && from != table {
+			return nil, fmt.Errorf("%w: query table [%s] from memory repository of [%s]", ErrUnsupported, from, table)
+		} else if !ok && reflect.Indirect(reflect.ValueOf(m.From)).Type() != repo.model.ModelType {
+			return nil, fmt.Errorf("%w: query %T from memory repository of %s", ErrUnsupported, m.From, repo.model.ModelType)
+		}
+	}
+	return m.Result, nil
+}
+
+func (repo *memrepo) filter(ctx context.Context, table string, opts ...MatchOption) ([]reflect.Value, error) {
+	if err := repo.ready(ctx); err != nil {
+		return nil, err
+	}
+	cond, err := repo.condition(table, opts)
+	if err != nil {
+		return nil, err
+	}
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	var rows []reflect.Value
+	for _, row := range repo.tables[table] {
+		if cond != nil {
+			ok, err := cond(row.Elem())
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+		}
+		rows = append(rows, repo.copy(row.Elem()))
+	}
+	return rows, nil
+}
+
+func (repo *memrepo) scan(table string, result any, rows []reflect.Value) error {
+	rv := reflect.ValueOf(result)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("result must be a non-nil pointer, got %T", result)
+	}
+	rv = rv.Elem()
+	switch rv.Kind() {
+	case reflect.Slice:
+		slice := reflect.MakeSlice(rv.Type(), 0, len(rows))
+		et := rv.Type().Elem()
+		isPtr := et.Kind() == reflect.Ptr
+		if isPtr {
+			et = et.Elem()
+		}
+		for _, row := range rows {
+			elem := reflect.New(et)
+			if err := repo.assign(table, elem.Elem(), row.Elem()); err != nil {
+				return err
+			}
+			if isPtr {
+				slice = reflect.Append(slice, elem)
+			} else {
+				slice = reflect.Append(slice, elem.Elem())
+			}
+		}
+		rv.Set(slice)
+	case reflect.Struct:
+		if len(rows) > 0 {
+			return repo.assign(table, rv, rows[0].Elem())
+		}
+	default:
+		return fmt.Errorf("%w: scan into %T", ErrUnsupported, result)
+	}
+	return nil
+}
+
+// assign copy src to dst, if dst is not the type of the model, the fields are
+// mapped like the select of the db repository does
+func (repo *memrepo) assign(table string, dst, src reflect.Value) error {
+	if dst.Type() == src.Type() {
+		dst.Set(src)
+		return nil
+	}
+	if dst.Kind() != reflect.Struct {
+		return fmt.Errorf("%w: scan into %s", ErrUnsupported, dst.Type())
+	}
+	for i := 0; i < dst.NumField(); i++ {
+		sf := dst.Type().Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		if sf.Anonymous && sf.Type.Kind() == reflect.Struct {
+			if err := repo.assign(table, dst.Field(i), src); err != nil {
+				return err
+			}
+			continue
+		}
+		column := sf.Tag.Get("field")
+		if column == "" {
+			column = utils.ToSnakeCase(sf.Name)
+		}
+		f, err := repo.lookup(table, column)
+		if err != nil {
+			return err
+		}
+		value := f.ReflectValueOf(context.Background(), src)
+		switch {
+		case value.Type().AssignableTo(sf.Type):
+			dst.Field(i).Set(value)
+		case value.Type().ConvertibleTo(sf.Type):
+			dst.Field(i).Set(value.Convert(sf.Type))
+		default:
+			return fmt.Errorf("can't assign %s to %s", value.Type(), sf.Type)
+		}
+	}
+	return nil
+}
+
+func (repo *memrepo) copy(v reflect.Value) reflect.Value {
+	row := reflect.New(repo.model.ModelType)
+	row.Elem().Set(v)
+	return row
+}
+
+func (repo *memrepo) zeroPrimaryKey(v reflect.Value) bool {
+	for _, f := range repo.model.PrimaryFields {
+		if _, zero := f.ValueOf(context.Background(), v); zero {
+			return true
+		}
+	}
+	return false
+}
+
+func (repo *memrepo) samePrimaryKey(a, b reflect.Value) bool {
+	if len(repo.model.PrimaryFields) == 0 {
+		return false
+	}
+	for _, f := range repo.model.PrimaryFields {
+		av, _ := f.ValueOf(context.Background(), a)
+		bv, _ := f.ValueOf(context.Background(), b)
+		if c, err := compare(memValue(av), memValue(bv)); err != nil || c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// ready report the error of NewMemory and the error of ctx
+func (repo *memrepo) ready(ctx context.Context) error {
+	if repo.err != nil {
+		return repo.err
+	}
+	return contextError(ctx)
+}
+
+// schema qualify the fields with table, each call reads the table once by ModelName and passes it
+// down, so it's consistent with the rows the call touches while SetTable runs concurrently
+func (repo *memrepo) schema(table string) Schema {
+	return plainSchema{table: table}
+}
+
+// lookup resolve the field names generated by the schema, quoted names and
+// names prefixed by the table are accepted
+func (repo *memrepo) lookup(table, name string) (*gschema.Field, error) {
+	column := strings.NewReplacer("`", "", `"`, "").Replace(strings.TrimSpace(name))
+	if i := strings.LastIndex(column, "."); i >= 0 {
+		if column[:i] != table {
+			return nil, fmt.Errorf("%w: field [%s] of other table", ErrUnsupported, name)
+		}
+		column = column[i+1:]
+	}
+	if f := repo.model.LookUpField(column); f != nil {
+		return f, nil
+	}
+	return nil, fmt.Errorf("unknown field: %s", name)
+}
+
+func (repo *memrepo) sort(table string, rows []reflect.Value, sorts []string) error {
+	type order struct {
+		field *gschema.Field
+		desc  bool
+	}
+	var orders []order
+	for _, s := range sorts {
+		for _, item := range strings.Split(s, ",") {
+			parts := strings.Fields(item)
+			if len(parts) == 0 {
+				continue
+			}
+			f, err := repo.lookup(table, parts[0])
+			if err != nil {
+				return err
+			}
+			o := order{field: f}
+			if len(parts) > 1 {
+				o.desc = strings.EqualFold(parts[1], "DESC")
+			}
+			orders = append(orders, o)
+		}
+	}
+	if len(orders) == 0 {
+		return nil
+	}
+	var err error
+	sort.SliceStable(rows, func(i, j int) bool {
+		for _, o := range orders {
+			a, _ := o.field.ValueOf(context.Background(), rows[i].Elem())
+			b, _ := o.field.ValueOf(context.Background(), rows[j].Elem())
+			c, e := compareNullable(memValue(a), memValue(b))
+			if e != nil {
+				err = e
+				return false
+			}
+			if c == 0 {
+				continue
+			}
+			return (c < 0) != o.desc
+		}
+		return false
+	})
+	return err
+}
+
+// condition compile the match options into a predicate with the trashed scope, a nil
+// predicate means there is no condition at all
+func (repo *memrepo) condition(table string, opts []MatchOption) (predicate, error) {
+	o := MatchOptions{schema: repo.schema(table)}
+	o.Apply(opts...)
+	var column string
+	if repo.softDelete != nil {
+		column = repo.schema(table).Field(repo.softDelete.DBName)
+	}
+	if err := o.scopeTrashed(column); err != nil {
+		return nil, err
+	}
+	if len(o.Matches) == 0 {
+		return nil, nil
+	}
+	return repo.compile(table, o.Matches)
+}
+
+// nested compile the options of OR, AND, Quote, And and Not
+func (repo *memrepo) nested(table string, opts []MatchOption) (predicate, error) {
+	o := MatchOptions{schema: repo.schema(table)}
+	o.Apply(opts...)
+	if len(o.Matches) == 0 {
+		return nil, nil
+	}
+	return repo.compile(table, o.Matches)
+}
+
+// compile follow the precedence of the sql compiled by the db repository, an OR
+// item starts a new group of conditions which are joined by AND
+func (repo *memrepo) compile(table string, items []MatchItem) (predicate, error) {
+	var groups [][]predicate
+	current := []predicate{}
+	for _, item := range items {
+		switch item.Operator {
+		case OR, AND, Quote, ALL:
+			opts, _ := item.Value.([]MatchOption)
+			sub, err := repo.nested(table, opts)
+			if err != nil {
+				return nil, err
+			}
+			if sub == nil {
+				sub = func(reflect.Value) (bool, error) { return true, nil }
+			}
+			if item.Operator == OR && len(current) > 0 {
+				groups = append(groups, current)
+				current = []predicate{}
+			}
+			current = append(current, sub)
+		case ANY:
+			opts, _ := item.Value.([]MatchOption)
+			o := MatchOptions{schema: repo.schema(table)}
+			o.Apply(opts...)
+			var disjuncts []predicate
+			for _, m := range o.Matches {
+				p, err := repo.compile(table, []MatchItem{m})
+				if err != nil {
+					return nil, err
+				}
+				disjuncts = append(disjuncts, p)
+			}
+			current = append(current, func(row reflect.Value) (bool, error) {
+				for _, p := range disjuncts {
+					if ok, err := p(row); err != nil || ok {
+						return ok, err
+					}
+				}
+				return len(disjuncts) == 0, nil
+			})
+		case NOT:
+			opts, _ := item.Value.([]MatchOption)
+			sub, err := repo.nested(table, opts)
+			if err != nil {
+				return nil, err
+			}
+			if sub == nil {
+				continue
+			}
+			current = append(current, func(row reflect.Value) (bool, error) {
+				ok, err := sub(row)
+				return !ok, err
+			})
+		default:
+			p, err := repo.predicate(table, item)
+			if err != nil {
+				return nil, err
+			}
+			current = append(current, p)
+		}
+	}
+	groups = append(groups, current)
+	return func(row reflect.Value) (bool, error) {
+		for _, group := range groups {
+			matched := true
+			for _, p := range group {
+				ok, err := p(row)
+				if err != nil {
+					return false, err
+				}
+				if !ok {
+					matched = false
+					break
+				}
+			}
+			if matched {
+				return true, nil
+			}
+		}
+		return false, nil
+	}, nil
+}
+
+func (repo *memrepo) predicate(table string, item MatchItem) (predicate, error) {
+	if _, ok := item.Value.(Subquery); ok || item.Operator == EXISTS || item.Operator == NOTEXISTS {
+		return nil, fmt.Errorf("%w: subquery in memory repository", ErrUnsupported)
+	}
+	if item.Operator == EXPR {
+		return nil, fmt.Errorf("%w: raw sql expression [%s] in memory repository", ErrUnsupported, item.Field)
+	}
+	f, err := repo.lookup(table, item.Field)
+	if err != nil {
+		return nil, err
+	}
+	left := func(row reflect.Value) any {
+		v, _ := f.ValueOf(context.Background(), row)
+		return memValue(v)
+	}
+	right := func(reflect.Value) any { return memValue(item.Value) }
+	if fld, ok := item.Value.(field); ok {
+		name, ok := fld.Field.(string)
+		if !ok {
+			return nil, fmt.Errorf("%w: compare with function in memory repository", ErrUnsupported)
+		}
+		ref, err := repo.lookup(table, name)
+		if err != nil {
+			return nil, err
+		}
+		right = func(row reflect.Value) any {
+			v, _ := ref.ValueOf(context.Background(), row)
+			return memValue(v)
+		}
+	}
+	switch item.Operator {
+	case NULL, NOTNULL:
+		return func(row reflect.Value) (bool, error) {
+			return (left(row) == nil) == (item.Operator == NULL), nil
+		}, nil
+	case EQ, NEQ, LT, LTE, GT, GTE:
+		return func(row reflect.Value) (bool, error) {
+			a, b := left(row), right(row)
+			if a == nil || b == nil {
+				return false, nil
+			}
+			c, err := compare(a, b)
+			if err != nil {
+				return false, err
+			}
+			switch item.Operator {
+			case EQ:
+				return c == 0, nil
+			case NEQ:
+				return c != 0, nil
+			case LT:
+				return c < 0, nil
+			case LTE:
+				return c <= 0, nil
+			case GT:
+				return c > 0, nil
+			default:
+				return c >= 0, nil
+			}
+		}, nil
+	case IN, NOTIN:
+		rv := reflect.ValueOf(item.Value)
+		if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+			return nil, fmt.Errorf("%s requires a slice, got %T", operatorMap[item.Operator], item.Value)
+		}
+		values := make([]any, rv.Len())
+		for i := range values {
+			values[i] = memValue(rv.Index(i).Interface())
+		}
+		return func(row reflect.Value) (bool, error) {
+			a := left(row)
+			if a == nil || len(values) == 0 {
+				return false, nil
+			}
+			for _, b := range values {
+				if b == nil {
+					continue
+				}
+				c, err := compare(a, b)
+				if err != nil {
+					return false, err
+				}
+				if c == 0 {
+					return item.Operator == IN, nil
+				}
+			}
+			return item.Operator == NOTIN, nil
+		}, nil
+	case LIKE, NOTLIKE, ILIKE, REGEXP:
+		pattern, ok := memValue(item.Value).(string)
+		if !ok {
+			return nil, fmt.Errorf("%s requires a string pattern, got %T", operatorMap[item.Operator], item.Value)
+		}
+		var re *regexp.Regexp
+		var err error
+		if item.Operator == REGEXP {
+			re, err = regexp.Compile("(?i)" + pattern)
+		} else {
+			re, err = likeRegexp(pattern)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return func(row reflect.Value) (bool, error) {
+			a := left(row)
+			if a == nil {
+				return false, nil
+			}
+			return re.MatchString(fmt.Sprint(a)) != (item.Operator == NOTLIKE), nil
+		}, nil
+	case BETWEEN, NOTBETWEEN:
+		rv := reflect.ValueOf(item.Value)
+		if (rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array) || rv.Len() != 2 {
+			return nil, fmt.Errorf("%s requires two bounds, got %v", operatorMap[item.Operator], item.Value)
+		}
+		low, high := memValue(rv.Index(0).Interface()), memValue(rv.Index(1).Interface())
+		return func(row reflect.Value) (bool, error) {
+			a := left(row)
+			if a == nil || low == nil || high == nil {
+				return false, nil
+			}
+			cl, err := compare(a, low)
+			if err != nil {
+				return false, err
+			}
+			ch, err := compare(a, high)
+			if err != nil {
+				return false, err
+			}
+			return (cl >= 0 && ch <= 0) == (item.Operator == BETWEEN), nil
+		}, nil
+	}
+	return nil, fmt.Errorf("%w: operator %d in memory repository", ErrUnsupported, item.Operator)
+}
+
+// likeRegexp translate the sql LIKE pattern, the match is case insensitive as
+// the default collations of mysql and sqlite are, so ILIKE is the same as LIKE
+func likeRegexp(pattern string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("(?is)^")
+	escaped := false
+	for _, r := range pattern {
+		switch {
+		case escaped:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '%':
+			b.WriteString(".*")
+		case r == '_':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+// memValue normalize a go value to nil, int64, float64, string, bool, time.Time
+// or the value itself when it can't be normalized
+func memValue(v any) any {
+	rv := reflect.ValueOf(v)
+	if !rv.IsValid() {
+		return nil
+	}
+	if (rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface) && rv.IsNil() {
+		return nil
+	}
+	if valuer, ok := v.(driver.Valuer); ok {
+		value, err := valuer.Value()
+		if err != nil {
+			return v
+		}
+		if _, ok := value.(driver.Valuer); !ok {
+			return memValue(value)
+		}
+	}
+	if rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+		return memValue(rv.Elem().Interface())
+	}
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return int64(rv.Uint())
+	case reflect.Float32, reflect.Float64:
+		return rv.Float()
+	case reflect.String:
+		return rv.String()
+	case reflect.Bool:
+		return rv.Bool()
+	case reflect.Slice:
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			return string(rv.Bytes())
+		}
+	}
+	return v
+}
+
+func numeric(v any) (float64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	case bool:
+		if n {
+			return 1, true
+		}
+		return 0, true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func memTime(v any) (time.Time, bool) {
+	switch t := v.(type) {
+	case time.Time:
+		return t, true
+	case string:
+		for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05.999999999", "2006-01-02"} {
+			if parsed, err := time.ParseInLocation(layout, t, time.Local); err == nil {
+				return parsed, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// compare compare normalized values, the values are converted like the
+// databases do when the types are different
+func compare(a, b any) (int, error) {
+	if x, ok := a.(int64); ok {
+		if y, ok := b.(int64); ok {
+			switch {
+			case x < y:
+				return -1, nil
+			case x > y:
+				return 1, nil
+			}
+			return 0, nil
+		}
+	}
+	if x, ok := a.(string); ok {
+		if y, ok := b.(string); ok {
+			return strings.Compare(x, y), nil
+		}
+	}
+	_, at := a.(time.Time)
+	_, bt := b.(time.Time)
+	if at || bt {
+		x, xok := memTime(a)
+		y, yok := memTime(b)
+		if xok && yok {
+			switch {
+			case x.Before(y):
+				return -1, nil
+			case x.After(y):
+				return 1, nil
+			}
+			return 0, nil
+		}
+	} else if x, ok := numeric(a); ok {
+		if y, ok := numeric(b); ok {
+			switch {
+			case x < y:
+				return -1, nil
+			case x > y:
+				return 1, nil
+			}
+			return 0, nil
+		}
+	}
+	if reflect.TypeOf(a) == reflect.TypeOf(b) && reflect.TypeOf(a).Comparable() {
+		if a == b {
+			return 0, nil
+		}
+	}
+	return 0, fmt.Errorf("can't compare %T with %T", a, b)
+}
+
+// compareNullable put null before the other values as mysql and sqlite do
+func compareNullable(a, b any) (int, error) {
+	switch {
+	case a == nil && b == nil:
+		return 0, nil
+	case a == nil:
+		return -1, nil
+	case b == nil:
+		return 1, nil
+	}
+	return compare(a, b)
+}
@@ -0,0 +1,160 @@
+package repository
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type Member struct {
+	ID   uint
+	Name string
+	Age  int
+	Nick *string
+}
+
+func TestMemoryRepository_Find(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemory(&Book{})
+	err := repo.Create(ctx, []*Book{
+		{ID: "1", Name: "hello", AuthorID: "1"},
+		{ID: "2", Name: "world", AuthorID: "2"},
+		{ID: "3", Name: "hello world", AuthorID: "3"},
+		{ID: "4", Name: "foo", AuthorID: "4"},
+	})
+	assert.Nil(t, err)
+	var books []Book
+	err = repo.Find(ctx, &books, AuthorID([]string{"1", "2", "3"}), func(opts *MatchOptions, schema Schema) {
+		opts.Quote(func(opts *MatchOptions, schema Schema) {
+			opts.LIKE(schema.Field("name"), "HELLO%").OR(func(opts *MatchOptions, schema Schema) {
+				opts.EQ(schema.Field("id"), "2")
+			})
+		})
+		opts.SetSort("id DESC")
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []Book{
+		{ID: "3", Name: "hello world", AuthorID: "3"},
+		{ID: "2", Name: "world", AuthorID: "2"},
+		{ID: "1", Name: "hello", AuthorID: "1"},
+	}, books)
+	var page []*Book
+	err = repo.Find(ctx, &page, func(opts *MatchOptions, schema Schema) {
+		opts.NotIN(schema.Field("id"), []string{"1"}).SetSort("name").SetLimit(2).SetOffset(1)
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []*Book{
+		{ID: "3", Name: "hello world", AuthorID: "3"},
+		{ID: "2", Name: "world", AuthorID: "2"},
+	}, page)
+	var count int64
+	err = repo.Count(ctx, GetModel(&count, &Book{}), AuthorID(Field("id")))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(4), count)
+	var first Book
+	err = repo.First(ctx, &first, func(opts *MatchOptions, schema Schema) { opts.GT(schema.Field("id"), "1") })
+	assert.Nil(t, err)
+	assert.Equal(t, "2", first.ID)
+	err = repo.First(ctx, &first, AuthorID("5"))
+	assert.Equal(t, ErrRecordNotFound, err)
+}
+
+func TestMemoryRepository_Write(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemory(&Member{})
+	members := []*Member{{Name: "a", Age: 10}, {Name: "b", Age: 20}, {Name: "c", Age: 30}}
+	assert.Nil(t, repo.Create(ctx, members))
+	assert.Equal(t, uint(3), members[2].ID)
+	assert.True(t, errors.Is(repo.Create(ctx, &Member{ID: 1}), ErrDuplicatedKey))
+	err := repo.UpdateFields(ctx, Fields{"nick": "bee"}, func(opts *MatchOptions, schema Schema) {
+		opts.GTE(schema.Field("age"), 20).LT(schema.Field("age"), 30)
+	})
+	assert.Nil(t, err)
+	var nicked []Member
+	err = repo.Find(ctx, &nicked, func(opts *MatchOptions, schema Schema) { opts.NotNull(schema.Field("nick")) })
+	assert.Nil(t, err)
+	assert.Len(t, nicked, 1)
+	assert.Equal(t, "bee", *nicked[0].Nick)
+	members[0].Age = 11
+	assert.Nil(t, repo.Update(ctx, members[0]))
+	assert.Nil(t, repo.Delete(ctx, func(opts *MatchOptions, schema Schema) { opts.Null(schema.Field("nick")).GT(schema.Field("age"), 20) }))
+	var left []struct {
+		Name string
+		Age  int
+	}
+	assert.Nil(t, repo.Find(ctx, GetModel(&left, &Member{}), func(opts *MatchOptions, schema Schema) { opts.SetSort("age") }))
+	assert.Equal(t, 2, len(left))
+	assert.Equal(t, 11, left[0].Age)
+	assert.Equal(t, "b", left[1].Name)
+	err = repo.Find(ctx, &left, func(opts *MatchOptions, schema Schema) { opts.EQ("users.name", "a") })
+	assert.True(t, errors.Is(err, ErrUnsupported))
+}
+
+// lockable refuses to be scanned once it's locked
+type lockable struct {
+	State string
+}
+
+func (l *lockable) Scan(v any) error {
+	if l.State == "locked" {
+		return errors.New("locked")
+	}
+	l.State = fmt.Sprint(v)
+	return nil
+}
+
+func (l lockable) Value() (driver.Value, error) {
+	return l.State, nil
+}
+
+type Lock struct {
+	ID    uint
+	State lockable
+}
+
+func TestMemoryRepository_UpdateFieldsAtomic(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemory(&Lock{})
+	assert.Nil(t, repo.Create(ctx, []*Lock{{State: lockable{"open"}}, {State: lockable{"locked"}}}))
+	err := repo.UpdateFields(ctx, Fields{"state": "closed"}, func(opts *MatchOptions, schema Schema) {
+		opts.IN(schema.Field("id"), []uint{1, 2})
+	})
+	assert.EqualError(t, err, "locked")
+	var locks []Lock
+	assert.Nil(t, repo.Find(ctx, &locks))
+	assert.Equal(t, []Lock{{ID: 1, State: lockable{"open"}}, {ID: 2, State: lockable{"locked"}}}, locks)
+}
+
+func TestNewMemory_Invalid(t *testing.T) {
+	ctx := context.Background()
+	for _, repo := range []RDBRepository{NewMemory(1), NewMemory(&Member{}, WithSoftDelete("removed_at"))} {
+		var members []Member
+		assert.NotNil(t, repo.Find(ctx, &members))
+		assert.NotNil(t, repo.Create(ctx, &Member{Name: "a"}))
+		assert.NotNil(t, repo.Delete(ctx, func(opts *MatchOptions, schema Schema) { opts.EQ(schema.Field("id"), 1) }))
+	}
+}
+
+func TestMemoryRepository_SetTable(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemory(&Member{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			repo.(TableSetter).SetTable(fmt.Sprintf("members_%d", i%2))
+		}
+	}()
+	for i := 0; i < 200; i++ {
+		assert.Nil(t, repo.Create(ctx, &Member{Name: "a"}))
+		var members []Member
+		assert.Nil(t, repo.Find(ctx, &members, func(opts *MatchOptions, schema Schema) {
+			opts.EQ(schema.Field("name"), "a").SetSort(schema.Field("id"))
+		}))
+	}
+	<-done
+}
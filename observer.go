@@ -0,0 +1,195 @@
+// Copyright (c) 2024 Yang,Zhong
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+package repository
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Observation describe an operation of the repository
+type Observation struct {
+	// Operation is the name of the method, such as Find and UpdateFields
+	Operation string
+	// Model is the table of the repository
+	Model string
+	// Sum is the sum of the match options, empty for the operations without them
+	Sum   string
+	Start time.Time
+	// Rows, Duration and Err are filled when the operation finishes, Rows is the rows
+	// affected by the writes and the rows found by the reads
+	Rows     int64
+	Duration time.Duration
+	Err      error
+}
+
+// Observer is notified around every operation called on the repository, the operations it runs
+// internally, such as the Find of FindPage, are part of the outermost one and not observed
+type Observer interface {
+	// OnStart is called before the operation, the context returned is passed to the operation
+	// and OnFinish, so a tracer can start its span here
+	OnStart(ctx context.Context, obs Observation) context.Context
+	OnFinish(ctx context.Context, obs Observation)
+}
+
+// WithObserver notify the observers around every operation, they are started in order and
+// finished in the reverse order
+//
+//	stats := NewStatsObserver()
+//	repo := New(db, &User{}, WithObserver(stats))
+func WithObserver(observers ...Observer) Option {
+	return func(opts *options) {
+		opts.observers = append(opts.observers, observers...)
+	}
+}
+
+type observingKey struct{}
+
+// observe run the operation between the OnStart and the OnFinish of the observers, the operations
+// run by it on the same repository are not observed again
+func (db *dbrepo) observe(ctx context.Context, op string, opts []MatchOption, run func(ctx context.Context) (int64, error)) error {
+	observers := db.options.observers
+	if len(observers) == 0 || ctx.Value(observingKey{}) == db {
+		_, err := run(ctx)
+		return err
+	}
+	ctx = context.WithValue(ctx, observingKey{}, db)
+	obs := Observation{Operation: op, Model: db.ModelName(), Start: time.Now()}
+	if opts != nil {
+		obs.Sum = Sum(opts...)
+	}
+	for _, o := range observers {
+		ctx = o.OnStart(ctx, obs)
+	}
+	obs.Rows, obs.Err = run(ctx)
+	obs.Duration = time.Since(obs.Start)
+	for i := len(observers) - 1; i >= 0; i-- {
+		observers[i].OnFinish(ctx, obs)
+	}
+	return obs.Err
+}
+
+// OperationStats is the durations of an operation aggregated by StatsObserver
+type OperationStats struct {
+	Count  int64
+	Errors int64
+	Rows   int64
+	Mean   time.Duration
+	Max    time.Duration
+	// the percentiles are computed over the latest samples
+	P50 time.Duration
+	P90 time.Duration
+	P99 time.Duration
+}
+
+type operationSamples struct {
+	stats   OperationStats
+	total   time.Duration
+	samples []time.Duration
+	next    int
+}
+
+// StatsObserver aggregate the durations of the operations in process, keyed by the operation
+type StatsObserver struct {
+	mu      sync.Mutex
+	size    int
+	samples map[string]*operationSamples
+}
+
+var _ Observer = &StatsObserver{}
+
+// NewStatsObserver keep the latest size samples of each operation for the percentiles, 1024 by default
+func NewStatsObserver(size ...int) *StatsObserver {
+	s := &StatsObserver{size: 1024, samples: make(map[string]*operationSamples)}
+	if len(size) > 0 && size[0] > 0 {
+		s.size = size[0]
+	}
+	return s
+}
+
+func (s *StatsObserver) OnStart(ctx context.Context, obs Observation) context.Context {
+	return ctx
+}
+
+func (s *StatsObserver) OnFinish(ctx context.Context, obs Observation) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	op, ok := s.samples[obs.Operation]
+	if !ok {
+		op = &operationSamples{}
+		s.samples[obs.Operation] = op
+	}
+	op.stats.Count++
+	op.stats.Rows += obs.Rows
+	if obs.Err != nil {
+		op.stats.Errors++
+	}
+	if obs.Duration > op.stats.Max {
+		op.stats.Max = obs.Duration
+	}
+	op.total += obs.Duration
+	if len(op.samples) < s.size {
+		op.samples = append(op.samples, obs.Duration)
+		return
+	}
+	op.samples[op.next] = obs.Duration
+	op.next = (op.next + 1) % s.size
+}
+
+// Stats get the stats of every operation observed
+func (s *StatsObserver) Stats() map[string]OperationStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ret := make(map[string]OperationStats, len(s.samples))
+	for name, op := range s.samples {
+		stats := op.stats
+		stats.Mean = op.total / time.Duration(stats.Count)
+		sorted := append([]time.Duration{}, op.samples...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		stats.P50 = percentile(sorted, 50)
+		stats.P90 = percentile(sorted, 90)
+		stats.P99 = percentile(sorted, 99)
+		ret[name] = stats
+	}
+	return ret
+}
+
+// Percentile get the p-th percentile of the durations of the operation, p is in (0, 100]
+func (s *StatsObserver) Percentile(operation string, p float64) time.Duration {
+	s.mu.Lock()
+	op, ok := s.samples[operation]
+	var sorted []time.Duration
+	if ok {
+		sorted = append(sorted, op.samples...)
+	}
+	s.mu.Unlock()
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return percentile(sorted, p)
+}
+
+// Reset drop the stats
+func (s *StatsObserver) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.samples = make(map[string]*operationSamples)
+}
+
+// percentile pick the nearest rank of the sorted durations
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
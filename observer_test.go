@@ -0,0 +1,111 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type observerKey struct{}
+
+type recordingObserver struct {
+	started  []string
+	finished []Observation
+}
+
+func (o *recordingObserver) OnStart(ctx context.Context, obs Observation) context.Context {
+	o.started = append(o.started, obs.Operation)
+	return context.WithValue(ctx, observerKey{}, obs.Operation)
+}
+
+func (o *recordingObserver) OnFinish(ctx context.Context, obs Observation) {
+	if ctx.Value(observerKey{}) == obs.Operation {
+		o.finished = append(o.finished, obs)
+	}
+}
+
+func TestObserver(t *testing.T) {
+	observer := &recordingObserver{}
+	stats := NewStatsObserver()
+	repo := New(sqliteDB(t, &Member{}), &Member{}, WithObserver(observer, stats))
+	ctx := context.Background()
+	young := func(opts *MatchOptions, schema Schema) { opts.LT(schema.Field("age"), 18) }
+
+	assert.Nil(t, repo.Create(ctx, []*Member{{Name: "a", Age: 10}, {Name: "b", Age: 12}, {Name: "c", Age: 30}}))
+	var members []Member
+	assert.Nil(t, repo.Find(ctx, &members, young))
+	assert.Nil(t, repo.UpdateFields(ctx, Fields{"nick": "kid"}, young))
+	var member Member
+	assert.ErrorIs(t, repo.First(ctx, &member, func(opts *MatchOptions, schema Schema) { opts.EQ(schema.Field("name"), "d") }), ErrRecordNotFound)
+	assert.Nil(t, repo.Delete(ctx, young))
+
+	assert.Equal(t, []string{"Create", "Find", "UpdateFields", "First", "Delete"}, observer.started)
+	assert.Equal(t, 5, len(observer.finished))
+	for _, obs := range observer.finished {
+		assert.Equal(t, "members", obs.Model)
+		assert.True(t, obs.Duration > 0)
+		assert.False(t, obs.Start.IsZero())
+	}
+	create, find, update, first, del := observer.finished[0], observer.finished[1], observer.finished[2], observer.finished[3], observer.finished[4]
+	assert.Equal(t, "", create.Sum)
+	assert.Equal(t, int64(3), create.Rows)
+	assert.Equal(t, Sum(young), find.Sum)
+	assert.Equal(t, int64(2), find.Rows)
+	assert.Equal(t, int64(2), update.Rows)
+	assert.True(t, errors.Is(first.Err, ErrRecordNotFound))
+	assert.Equal(t, int64(2), del.Rows)
+
+	s := stats.Stats()
+	assert.Equal(t, int64(1), s["Find"].Count)
+	assert.Equal(t, int64(1), s["First"].Errors)
+	assert.Equal(t, int64(2), s["Delete"].Rows)
+}
+
+func TestObserver_Nested(t *testing.T) {
+	observer := &recordingObserver{}
+	stats := NewStatsObserver()
+	repo := New(sqliteDB(t, &Member{}), &Member{}, WithObserver(observer, stats))
+	ctx := context.Background()
+	assert.Nil(t, repo.CreateInBatches(ctx, []*Member{{Name: "a"}, {Name: "b"}, {Name: "c"}}, 2))
+	var members []Member
+	_, err := repo.FindPage(ctx, &members, func(opts *MatchOptions, schema Schema) { opts.SetCursor("", "id").SetLimit(2) })
+	assert.Nil(t, err)
+	_, err = repo.Paginate(ctx, &members, 1, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"CreateInBatches", "FindPage", "Paginate"}, observer.started)
+	assert.Equal(t, int64(3), observer.finished[0].Rows)
+	s := stats.Stats()
+	assert.Equal(t, 3, len(s))
+	assert.Equal(t, int64(0), s["Find"].Count)
+	assert.Equal(t, int64(0), s["Create"].Count)
+	assert.Nil(t, repo.Find(ctx, &members))
+	assert.Equal(t, int64(1), stats.Stats()["Find"].Count)
+}
+
+func TestStatsObserver(t *testing.T) {
+	stats := NewStatsObserver(100)
+	for i := 1; i <= 200; i++ {
+		var err error
+		if i%50 == 0 {
+			err = errors.New("failed")
+		}
+		stats.OnFinish(context.Background(), Observation{Operation: "Find", Duration: time.Duration(i) * time.Millisecond, Rows: 1, Err: err})
+	}
+	s := stats.Stats()["Find"]
+	assert.Equal(t, int64(200), s.Count)
+	assert.Equal(t, int64(4), s.Errors)
+	assert.Equal(t, int64(200), s.Rows)
+	assert.Equal(t, 200*time.Millisecond, s.Max)
+	assert.Equal(t, 100500*time.Microsecond, s.Mean)
+	// only the latest 100 samples are kept
+	assert.Equal(t, 150*time.Millisecond, s.P50)
+	assert.Equal(t, 190*time.Millisecond, s.P90)
+	assert.Equal(t, 199*time.Millisecond, s.P99)
+	assert.Equal(t, 101*time.Millisecond, stats.Percentile("Find", 1))
+	assert.Equal(t, time.Duration(0), stats.Percentile("Count", 50))
+	stats.Reset()
+	assert.Equal(t, 0, len(stats.Stats()))
+}
@@ -0,0 +1,85 @@
+// Copyright (c) 2024 Yang,Zhong
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+package repository
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// EscapeLike escape the wildcards of LIKE in s, the escape character is backslash
+func EscapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+func (opts *MatchOptions) Between(field string, low, high any) *MatchOptions {
+	return opts.oper(field, BETWEEN, []any{low, high})
+}
+
+func (opts *MatchOptions) NotBetween(field string, low, high any) *MatchOptions {
+	return opts.oper(field, NOTBETWEEN, []any{low, high})
+}
+
+func (opts *MatchOptions) NotLike(field string, val any) *MatchOptions {
+	return opts.oper(field, NOTLIKE, val)
+}
+
+// ILike match the pattern case insensitively, it's emulated by LOWER() where ILIKE is not supported
+func (opts *MatchOptions) ILike(field string, val any) *MatchOptions {
+	return opts.oper(field, ILIKE, val)
+}
+
+// Regexp match the pattern by REGEXP, or ~ on postgres. sqlite requires a regexp function
+// registered to the connection
+func (opts *MatchOptions) Regexp(field string, pattern string) *MatchOptions {
+	return opts.oper(field, REGEXP, pattern)
+}
+
+// StartsWith match the values starting with prefix, the wildcards in it are escaped
+func (opts *MatchOptions) StartsWith(field string, prefix string) *MatchOptions {
+	return opts.LIKE(field, EscapeLike(prefix)+"%")
+}
+
+// EndsWith match the values ending with suffix, the wildcards in it are escaped
+func (opts *MatchOptions) EndsWith(field string, suffix string) *MatchOptions {
+	return opts.LIKE(field, "%"+EscapeLike(suffix))
+}
+
+// Contains match the values containing s, the wildcards in it are escaped
+func (opts *MatchOptions) Contains(field string, s string) *MatchOptions {
+	return opts.LIKE(field, "%"+EscapeLike(s)+"%")
+}
+
+func betweenBounds(v any) (low, high any, err error) {
+	rv := reflect.ValueOf(v)
+	if (rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array) || rv.Len() != 2 {
+		return nil, nil, fmt.Errorf("BETWEEN requires two bounds, got %v", v)
+	}
+	return rv.Index(0).Interface(), rv.Index(1).Interface(), nil
+}
+
+// dialectOperator compile the comparison for the dialect of the repository
+func (repo *dbrepo) dialectOperator(left string, operator Operator, op, right string) string {
+	dialect := repo.dialect()
+	switch operator {
+	case ILIKE:
+		if dialect != "postgres" {
+			left, op, right = "LOWER("+left+")", "LIKE", "LOWER("+right+")"
+		}
+	case REGEXP:
+		if dialect == "postgres" {
+			op = "~"
+		}
+	}
+	ret := fmt.Sprintf("%s %s %s", left, op, right)
+	// backslash is the default escape character of mysql and postgres but not sqlite
+	if dialect == "sqlite" && (operator == LIKE || operator == NOTLIKE || operator == ILIKE) {
+		ret += ` ESCAPE '\'`
+	}
+	return ret
+}
@@ -0,0 +1,88 @@
+package repository
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestGormRepository_Operators(t *testing.T) {
+	db, mock, err := sqlmock.New() // mock sql.DB
+	assert.Nil(t, err)
+	defer db.Close()
+	defer assert.Nil(t, mock.ExpectationsWereMet())
+	gdb, err := gorm.Open(dialector(db)) // open gorm db
+	assert.Nil(t, err)
+	repo := New(gdb, &Book{})
+	func() {
+		execSql := "^SELECT \\* FROM `books` WHERE `books`.`id` BETWEEN \\? AND \\? AND `books`.`name` NOT LIKE \\? AND LOWER\\(`books`.`name`\\) LIKE LOWER\\(\\?\\) AND `books`.`name` REGEXP \\? AND `books`.`author_id` LIKE \\?$"
+		mock.ExpectQuery(execSql).
+			WithArgs("1", "9", "%x%", "%Y%", "^a", `50\%\_off\\%`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "author_id"}))
+	}()
+	var books []Book
+	err = repo.Find(context.Background(), &books, func(opts *MatchOptions, schema Schema) {
+		opts.Between(schema.Field("id"), "1", "9").
+			NotLike(schema.Field("name"), "%x%").
+			ILike(schema.Field("name"), "%Y%").
+			Regexp(schema.Field("name"), "^a").
+			StartsWith(schema.Field("author_id"), `50%_off\`)
+	})
+	assert.Nil(t, err)
+}
+
+func testOperators(t *testing.T, repo RDBRepository) {
+	ctx := context.Background()
+	assert.Nil(t, repo.Create(ctx, []*Member{{Name: "a_b", Age: 10}, {Name: "axb", Age: 20}, {Name: "Alice", Age: 30}, {Name: "100%", Age: 40}}))
+	names := func(opt MatchOption) []string {
+		var members []Member
+		assert.Nil(t, repo.Find(ctx, &members, opt, func(opts *MatchOptions, schema Schema) { opts.SetSort("id") }))
+		var ret []string
+		for _, m := range members {
+			ret = append(ret, m.Name)
+		}
+		return ret
+	}
+	assert.Equal(t, []string{"axb", "Alice"}, names(func(opts *MatchOptions, schema Schema) { opts.Between(schema.Field("age"), 20, 30) }))
+	assert.Equal(t, []string{"a_b", "100%"}, names(func(opts *MatchOptions, schema Schema) { opts.NotBetween(schema.Field("age"), 20, 30) }))
+	assert.Equal(t, []string{"a_b"}, names(func(opts *MatchOptions, schema Schema) { opts.StartsWith(schema.Field("name"), "a_") }))
+	assert.Equal(t, []string{"100%"}, names(func(opts *MatchOptions, schema Schema) { opts.EndsWith(schema.Field("name"), "0%") }))
+	assert.Equal(t, []string{"a_b"}, names(func(opts *MatchOptions, schema Schema) { opts.Contains(schema.Field("name"), "_") }))
+	assert.Equal(t, []string{"Alice", "100%"}, names(func(opts *MatchOptions, schema Schema) { opts.NotLike(schema.Field("name"), "a_b") }))
+	assert.Equal(t, []string{"Alice"}, names(func(opts *MatchOptions, schema Schema) { opts.ILike(schema.Field("name"), "ALI%") }))
+}
+
+func TestOperators(t *testing.T) {
+	testOperators(t, New(sqliteDB(t, &Member{}), &Member{}))
+}
+
+func TestMemoryRepository_Operators(t *testing.T) {
+	repo := NewMemory(&Member{})
+	testOperators(t, repo)
+	var members []Member
+	assert.Nil(t, repo.Find(context.Background(), &members, func(opts *MatchOptions, schema Schema) {
+		opts.Regexp(schema.Field("name"), "^a.b$")
+	}))
+	assert.Equal(t, 2, len(members))
+}
+
+func TestOperators_InvalidBounds(t *testing.T) {
+	for _, repo := range []RDBRepository{New(sqliteDB(t, &Member{}), &Member{}), NewMemory(&Member{})} {
+		var members []Member
+		err := repo.Find(context.Background(), &members, func(opts *MatchOptions, schema Schema) {
+			opts.oper(schema.Field("age"), BETWEEN, []any{20})
+		})
+		assert.ErrorContains(t, err, "requires two bounds")
+		err = repo.Find(context.Background(), &members, func(opts *MatchOptions, schema Schema) {
+			opts.oper(schema.Field("age"), NOTBETWEEN, 20)
+		})
+		assert.ErrorContains(t, err, "requires two bounds")
+	}
+}
+
+func TestEscapeLike(t *testing.T) {
+	assert.Equal(t, `50\%\_off\\`, EscapeLike(`50%_off\`))
+}
@@ -0,0 +1,58 @@
+// Copyright (c) 2024 Yang,Zhong
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+package repository
+
+import (
+	"context"
+	"time"
+)
+
+// Option configure the repository, it's passed to New and NewWithTable alongside the model
+//
+//	repo := New(db, &User{}, WithQueryTimeout(3*time.Second))
+type Option func(opts *options)
+
+type options struct {
+	timeout    time.Duration
+	outbox     bool
+	softDelete string
+	observers  []Observer
+}
+
+// WithQueryTimeout set the timeout of each operation whose context has no deadline
+func WithQueryTimeout(timeout time.Duration) Option {
+	return func(opts *options) {
+		opts.timeout = timeout
+	}
+}
+
+// WithOutbox store the change event of each write in the outbox table in the same transaction,
+// the events are delivered by OutboxRelay
+func WithOutbox() Option {
+	return func(opts *options) {
+		opts.outbox = true
+	}
+}
+
+// splitArgs separate the model from the options
+func splitArgs(args []any) (model any, opts options) {
+	for _, arg := range args {
+		if apply, ok := arg.(Option); ok {
+			apply(&opts)
+			continue
+		}
+		if model == nil {
+			model = arg
+		}
+	}
+	return
+}
+
+func (opts options) context(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok || opts.timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, opts.timeout)
+}
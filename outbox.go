@@ -0,0 +1,258 @@
+// Copyright (c) 2024 Yang,Zhong
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+)
+
+// OutboxMessage is the row of the outbox table, migrate it with MigrateOutbox
+type OutboxMessage struct {
+	ID          uint64
+	Model       string `gorm:"size:128"`
+	Type        string `gorm:"size:32"`
+	Payload     []byte
+	OccurredAt  time.Time
+	DeliveredAt *time.Time `gorm:"index"`
+	Attempts    int
+	LastError   string `gorm:"size:1024"`
+}
+
+// lastErrorSize is the size of OutboxMessage.LastError
+const lastErrorSize = 1024
+
+func (OutboxMessage) TableName() string {
+	return "repository_outbox"
+}
+
+type outboxPayload struct {
+	Data   any           `json:"data,omitempty"`
+	Filter []outboxMatch `json:"filter,omitempty"`
+}
+
+type outboxMatch struct {
+	Field    string        `json:"field,omitempty"`
+	Operator Operator      `json:"operator"`
+	Value    any           `json:"value,omitempty"`
+	Matches  []outboxMatch `json:"matches,omitempty"`
+}
+
+// MigrateOutbox create or migrate the outbox table
+func MigrateOutbox(db *gorm.DB) error {
+	return db.AutoMigrate(&OutboxMessage{})
+}
+
+func newOutboxMessage(event ChangeEvent) (*OutboxMessage, error) {
+	payload, err := json.Marshal(outboxPayload{Data: event.Data, Filter: outboxMatches(event.Filter)})
+	if err != nil {
+		return nil, err
+	}
+	return &OutboxMessage{
+		Model:      event.Model,
+		Type:       event.Type.String(),
+		Payload:    payload,
+		OccurredAt: event.Time,
+	}, nil
+}
+
+// Event decode the message, the Data of the event is a json.RawMessage
+func (msg OutboxMessage) Event() (ChangeEvent, error) {
+	var payload struct {
+		Data   json.RawMessage `json:"data"`
+		Filter []outboxMatch   `json:"filter"`
+	}
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		return ChangeEvent{}, err
+	}
+	event := ChangeEvent{
+		ChangeData: ChangeData{Model: msg.Model},
+		Time:       msg.OccurredAt,
+	}
+	if len(payload.Data) > 0 {
+		event.Data = payload.Data
+	}
+	for typ, name := range changeTypeNames {
+		if name == msg.Type {
+			event.Type = typ
+		}
+	}
+	if event.Type == 0 {
+		return event, fmt.Errorf("unknown change type: %s", msg.Type)
+	}
+	if payload.Filter != nil {
+		event.Filter = MatchOptions{schema: plainSchema{}}
+		event.Filter.Matches = matchItems(payload.Filter)
+	}
+	return event, nil
+}
+
+func outboxMatches(opts MatchOptions) []outboxMatch {
+	var matches []outboxMatch
+	for _, m := range opts.Matches {
+		om := outboxMatch{Field: m.Field, Operator: m.Operator, Value: m.Value}
+		if nested, ok := m.Value.([]MatchOption); ok {
+			sub := MatchOptions{schema: opts.schema}
+			sub.Apply(nested...)
+			om.Value = nil
+			om.Matches = outboxMatches(sub)
+			if om.Matches == nil {
+				om.Matches = []outboxMatch{}
+			}
+		}
+		matches = append(matches, om)
+	}
+	return matches
+}
+
+func matchItems(matches []outboxMatch) []MatchItem {
+	items := make([]MatchItem, 0, len(matches))
+	for _, m := range matches {
+		item := MatchItem{Field: m.Field, Operator: m.Operator, Value: m.Value}
+		if m.Matches != nil {
+			nested := matchItems(m.Matches)
+			item.Value = []MatchOption{func(opts *MatchOptions, schema Schema) {
+				opts.Matches = append(opts.Matches, nested...)
+			}}
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+type OutboxRelay struct {
+	db          *gorm.DB
+	publisher   Publisher
+	batchSize   int
+	interval    time.Duration
+	maxAttempts int
+	onError     func(err error)
+}
+
+type RelayOption func(relay *OutboxRelay)
+
+// RelayBatchSize set the number of messages fetched by each poll, default 100
+func RelayBatchSize(size int) RelayOption {
+	return func(relay *OutboxRelay) {
+		relay.batchSize = size
+	}
+}
+
+// RelayInterval set the interval between polls when the outbox is drained, default 1s
+func RelayInterval(interval time.Duration) RelayOption {
+	return func(relay *OutboxRelay) {
+		relay.interval = interval
+	}
+}
+
+// RelayMaxAttempts set the attempts of publishing a message before giving up on it, default 10
+func RelayMaxAttempts(attempts int) RelayOption {
+	return func(relay *OutboxRelay) {
+		relay.maxAttempts = attempts
+	}
+}
+
+// RelayErrorHandler handle the errors Run recovers from
+func RelayErrorHandler(handler func(err error)) RelayOption {
+	return func(relay *OutboxRelay) {
+		relay.onError = handler
+	}
+}
+
+// NewOutboxRelay create a relay which hands the events in the outbox table to publisher,
+// the delivery is at least once, only one relay should run against a database
+// usage:
+//
+//	repo := New(db, &User{}, WithOutbox())
+//	relay := NewOutboxRelay(db, publisher, RelayInterval(time.Second))
+//	go relay.Run(ctx)
+func NewOutboxRelay(db *gorm.DB, publisher Publisher, opts ...RelayOption) *OutboxRelay {
+	relay := &OutboxRelay{
+		db:          db,
+		publisher:   publisher,
+		batchSize:   100,
+		interval:    time.Second,
+		maxAttempts: 10,
+		onError:     func(error) {},
+	}
+	for _, apply := range opts {
+		apply(relay)
+	}
+	return relay
+}
+
+// Run relay the messages until ctx is done
+func (relay *OutboxRelay) Run(ctx context.Context) error {
+	ticker := time.NewTicker(relay.interval)
+	defer ticker.Stop()
+	for {
+		n, err := relay.Relay(ctx)
+		if err != nil {
+			if cerr := contextError(ctx); cerr != nil {
+				return cerr
+			}
+			relay.onError(err)
+		}
+		if err == nil && n == relay.batchSize {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return contextError(ctx)
+		case <-ticker.C:
+		}
+	}
+}
+
+// Relay publish a batch of undelivered messages in order, it stops at the first message
+// which fails to publish, the failure is recorded on the message and retried next time
+func (relay *OutboxRelay) Relay(ctx context.Context) (delivered int, err error) {
+	var msgs []OutboxMessage
+	err = relay.db.WithContext(ctx).
+		Where("delivered_at IS NULL AND attempts < ?", relay.maxAttempts).
+		Order("id").Limit(relay.batchSize).Find(&msgs).Error
+	if err != nil {
+		return 0, err
+	}
+	for _, msg := range msgs {
+		event, err := msg.Event()
+		if err == nil {
+			err = relay.publisher.Publish(ctx, event)
+		}
+		if err != nil {
+			fields := map[string]any{"attempts": gorm.Expr("attempts + 1"), "last_error": truncate(err.Error(), lastErrorSize)}
+			if uerr := relay.mark(ctx, msg.ID, fields); uerr != nil {
+				return delivered, uerr
+			}
+			return delivered, fmt.Errorf("publish outbox message %d: %w", msg.ID, err)
+		}
+		fields := map[string]any{"attempts": gorm.Expr("attempts + 1"), "delivered_at": time.Now(), "last_error": ""}
+		if err := relay.mark(ctx, msg.ID, fields); err != nil {
+			return delivered, err
+		}
+		delivered++
+	}
+	return delivered, nil
+}
+
+func (relay *OutboxRelay) mark(ctx context.Context, id uint64, fields map[string]any) error {
+	return relay.db.WithContext(ctx).Model(&OutboxMessage{}).Where("id = ?", id).Updates(fields).Error
+}
+
+// truncate cut s to at most n bytes without splitting a rune
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
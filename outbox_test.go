@@ -0,0 +1,77 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type failingPublisher struct {
+	err error
+}
+
+func (p failingPublisher) Publish(ctx context.Context, events ...ChangeEvent) error {
+	return p.err
+}
+
+func TestOutbox(t *testing.T) {
+	db := sqliteDB(t, &Book{})
+	assert.Nil(t, MigrateOutbox(db))
+	ctx := context.Background()
+	repo := New(db, &Book{}, WithOutbox())
+	assert.Nil(t, repo.Create(ctx, &Book{ID: "1", AuthorID: "1"}))
+	assert.Nil(t, repo.UpdateFields(ctx, Fields{"name": "hello"}, AuthorID("1")))
+	assert.NotNil(t, repo.Create(ctx, &Book{ID: "1"}))
+	var msgs []OutboxMessage
+	assert.Nil(t, db.Find(&msgs).Error)
+	assert.Len(t, msgs, 2)
+
+	failure := errors.New("broker down")
+	_, err := NewOutboxRelay(db, failingPublisher{err: failure}).Relay(ctx)
+	assert.True(t, errors.Is(err, failure))
+	var failed OutboxMessage
+	assert.Nil(t, db.First(&failed).Error)
+	assert.Equal(t, 1, failed.Attempts)
+	assert.Equal(t, failure.Error(), failed.LastError)
+
+	bus := NewEventBus()
+	var events []ChangeEvent
+	bus.Subscribe(func(ctx context.Context, event ChangeEvent) error {
+		events = append(events, event)
+		return nil
+	}, "books")
+	delivered, err := NewOutboxRelay(db, bus).Relay(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, delivered)
+	assert.Equal(t, Created, events[0].Type)
+	var book Book
+	assert.Nil(t, json.Unmarshal(events[0].Data.(json.RawMessage), &book))
+	assert.Equal(t, Book{ID: "1", AuthorID: "1"}, book)
+	assert.Equal(t, FieldsUpdated, events[1].Type)
+	assert.Equal(t, []MatchItem{{Field: "books.author_id", Operator: EQ, Value: "1"}}, events[1].Filter.Matches)
+	delivered, err = NewOutboxRelay(db, bus).Relay(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, delivered)
+}
+
+func TestOutboxRelay_LongError(t *testing.T) {
+	db := sqliteDB(t, &Book{})
+	assert.Nil(t, MigrateOutbox(db))
+	ctx := context.Background()
+	repo := New(db, &Book{}, WithOutbox())
+	assert.Nil(t, repo.Create(ctx, &Book{ID: "1"}))
+	failure := errors.New("ab" + strings.Repeat("错", 1024))
+	_, err := NewOutboxRelay(db, failingPublisher{err: failure}).Relay(ctx)
+	assert.True(t, errors.Is(err, failure))
+	var failed OutboxMessage
+	assert.Nil(t, db.First(&failed).Error)
+	assert.Equal(t, 1, failed.Attempts)
+	assert.Equal(t, 1022, len(failed.LastError))
+	assert.True(t, utf8.ValidString(failed.LastError))
+	assert.True(t, strings.HasPrefix(failure.Error(), failed.LastError))
+}
@@ -0,0 +1,60 @@
+// Copyright (c) 2024 Yang,Zhong
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+package repository
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+)
+
+// Page is the result of Paginate
+type Page struct {
+	// Items is the result passed to Paginate, the Result of it if it's a *Model
+	Items any
+	// Total is the number of the records matched regardless of the page
+	Total int64
+	// Page starts from 1
+	Page       int
+	Size       int
+	TotalPages int
+}
+
+// unpaged drop the limit, offset, sort and cursor of the options, it's applied for counting
+func unpaged(opts *MatchOptions, schema Schema) {
+	opts.Sort, opts.Order, opts.Limit, opts.Offset, opts.Cursor = nil, nil, nil, nil, nil
+}
+
+// paginate count the records and then find the records of the page,
+// the find is skipped if the page is beyond the total
+func paginate(ctx context.Context, count func(ctx context.Context) (int64, error), find func(context.Context, any, ...MatchOption) error, v any, page, size int, opts []MatchOption) (Page, error) {
+	if size <= 0 {
+		return Page{}, fmt.Errorf("paginate requires a positive size, got %d", size)
+	}
+	if page < 1 {
+		page = 1
+	}
+	p := Page{Items: v, Page: page, Size: size}
+	if m, ok := v.(*Model); ok {
+		p.Items = m.Result
+	}
+	total, err := count(ctx)
+	if err != nil {
+		return p, err
+	}
+	p.Total = total
+	p.TotalPages = int((total + int64(size) - 1) / int64(size))
+	if int64(page-1)*int64(size) >= total {
+		// the items of the previous call are dropped where the result is reused
+		if rv := reflect.ValueOf(p.Items); rv.Kind() == reflect.Ptr && rv.Elem().Kind() == reflect.Slice {
+			rv.Elem().SetLen(0)
+		}
+		return p, nil
+	}
+	query := append(append([]MatchOption{}, opts...), func(opts *MatchOptions, schema Schema) {
+		opts.SetLimit(size).SetOffset((page - 1) * size)
+	})
+	return p, find(ctx, v, query...)
+}
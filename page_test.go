@@ -0,0 +1,73 @@
+package repository
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testPaginate(t *testing.T, repo RDBRepository) {
+	ctx := context.Background()
+	assert.Nil(t, repo.Create(ctx, []*Member{{Age: 30}, {Age: 20}, {Age: 20}, {Age: 10}, {Age: 40}}))
+	var members []Member
+	p, err := repo.Paginate(ctx, &members, 2, 2, func(opts *MatchOptions, schema Schema) {
+		opts.GT(schema.Field("age"), 10).SetSort("age DESC", "id").SetLimit(1)
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []uint{2, 3}, ids(members))
+	assert.Equal(t, Page{Items: &members, Total: 4, Page: 2, Size: 2, TotalPages: 2}, p)
+	members = nil
+	p, err = repo.Paginate(ctx, &members, 3, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, []uint{5}, ids(members))
+	assert.Equal(t, int64(5), p.Total)
+	assert.Equal(t, 3, p.TotalPages)
+	p, err = repo.Paginate(ctx, &members, 4, 2)
+	assert.Nil(t, err)
+	assert.Empty(t, members)
+	assert.Equal(t, &members, p.Items)
+	cp, err := repo.FindPage(ctx, &members, func(opts *MatchOptions, schema Schema) {
+		opts.SetCursor("", "id").SetLimit(2)
+	})
+	assert.Nil(t, err)
+	p, err = repo.Paginate(ctx, &members, 1, 2, func(opts *MatchOptions, schema Schema) {
+		opts.SetCursor(cp.Next, "id")
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5), p.Total)
+	_, err = repo.Paginate(ctx, &members, 1, 0)
+	assert.NotNil(t, err)
+}
+
+func TestPaginate(t *testing.T) {
+	testPaginate(t, New(sqliteDB(t, &Member{}), &Member{}))
+}
+
+func TestMemoryRepository_Paginate(t *testing.T) {
+	testPaginate(t, NewMemory(&Member{}))
+}
+
+func TestPaginate_model(t *testing.T) {
+	ctx := context.Background()
+	db := sqliteDB(t, &User{}, &Book{})
+	assert.Nil(t, New(db, &User{}).Create(ctx, []*User{{ID: "1", Name: "a"}, {ID: "2", Name: "b"}}))
+	repo := New(db, &Book{})
+	assert.Nil(t, repo.Create(ctx, []*Book{{ID: "1", AuthorID: "1"}, {ID: "2", AuthorID: "1"}, {ID: "3", AuthorID: "2"}}))
+	var books []BookWithUserWithoutFromField
+	p, err := repo.Paginate(ctx, GetModel(&books, &Book{}).With(&User{}, AuthorID(Field("users.id"))), 1, 2, func(opts *MatchOptions, schema Schema) {
+		opts.SetSort("books.id DESC")
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), p.Total)
+	assert.Equal(t, &books, p.Items)
+	assert.Equal(t, []string{"3", "2"}, []string{books[0].ID, books[1].ID})
+	assert.Equal(t, "b", books[0].AuthorName)
+	var groups []GroupTest
+	p, err = repo.Paginate(ctx, GetModel(&groups, &Book{}).Group("author_id"), 1, 1, func(opts *MatchOptions, schema Schema) {
+		opts.SetSort("author_id")
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), p.Total)
+	assert.Equal(t, []GroupTest{{AuthorID: "1", Books: 2}}, groups)
+}
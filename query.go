@@ -0,0 +1,379 @@
+// Copyright (c) 2024 Yang,Zhong
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+package repository
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// queryOperators is the operators of the query filters, the longer ones come first
+var queryOperators = []struct {
+	token string
+	op    Operator
+}{
+	{">=", GTE}, {"<=", LTE}, {"!=", NEQ}, {"!~", NOTLIKE}, {">", GT}, {"<", LT}, {"=", EQ}, {"~", LIKE},
+}
+
+// ParseQuery parse the filters of the url query, the conditions of filter are joined by AND
+//
+//	?filter=age>=18,name~jo*,role=admin|owner,deleted_at=null&sort=-created_at,id&limit=20&offset=40
+//
+// the operators are >=, <=, !=, >, <, =, ~ (LIKE) and !~ (NOT LIKE). * is the wildcard of ~,
+// | separates the values of = (IN) and != (NOT IN), and = null, != null match the NULLs.
+// the values like numbers, true and false are typed, quote them to keep them strings, and a
+// backslash escapes the character after it. the fields are mapped through the schema, only
+// the ones in allow are accepted, and the errors wrap ErrInvalidFilter
+// usage:
+//
+//	opts, err := ParseQuery(r.URL.Query(), AllowList{"name": nil, "age": nil, "created_at": nil})
+func ParseQuery(query url.Values, allow AllowList) ([]MatchOption, error) {
+	var ret []MatchOption
+	for _, filter := range query["filter"] {
+		conds, err := splitQuery(filter, ',')
+		if err != nil {
+			return nil, err
+		}
+		for _, cond := range conds {
+			opt, err := parseCondition(cond, allow)
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, opt)
+		}
+	}
+	if sort := query.Get("sort"); sort != "" {
+		var sorts []string
+		for _, key := range strings.Split(sort, ",") {
+			if strings.HasPrefix(key, "-") {
+				sorts = append(sorts, strings.TrimPrefix(key, "-")+" DESC")
+				continue
+			}
+			sorts = append(sorts, key)
+		}
+		opt, err := parseSort(sorts, allow)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, opt)
+	}
+	for _, name := range []string{"limit", "offset"} {
+		s := query.Get(name)
+		if s == "" {
+			continue
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("%w: %s [%s] is not a non-negative integer", ErrInvalidFilter, name, s)
+		}
+		if name == "limit" {
+			ret = append(ret, func(opts *MatchOptions, schema Schema) { opts.SetLimit(n) })
+		} else {
+			ret = append(ret, func(opts *MatchOptions, schema Schema) { opts.SetOffset(n) })
+		}
+	}
+	return ret, nil
+}
+
+func parseCondition(cond string, allow AllowList) (MatchOption, error) {
+	end := 0
+	for end < len(cond) && isQueryField(cond[end]) {
+		end++
+	}
+	name, rest := cond[:end], cond[end:]
+	if name == "" {
+		return nil, fmt.Errorf("%w: condition [%s] starts without a field", ErrInvalidFilter, cond)
+	}
+	var op Operator
+	found := false
+	for _, o := range queryOperators {
+		if strings.HasPrefix(rest, o.token) {
+			op, rest, found = o.op, rest[len(o.token):], true
+			break
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("%w: unknown operator in condition [%s]", ErrInvalidFilter, cond)
+	}
+	pieces, err := splitQuery(rest, '|')
+	if err != nil {
+		return nil, err
+	}
+	if len(pieces) > 1 && op != EQ && op != NEQ {
+		return nil, fmt.Errorf("%w: condition [%s] has more than one value, escape the | by \\|", ErrInvalidFilter, cond)
+	}
+	var values []any
+	for _, piece := range pieces {
+		v, err := parseQueryValue(piece, op == LIKE || op == NOTLIKE)
+		if err != nil {
+			return nil, fmt.Errorf("%w in condition [%s]", err, cond)
+		}
+		values = append(values, v)
+	}
+	var value any = values[0]
+	switch {
+	case len(values) > 1 && op == EQ:
+		op, value = IN, values
+	case len(values) > 1:
+		op, value = NOTIN, values
+	case pieces[0] == "null" && op == EQ:
+		op, value = NULL, nil
+	case pieces[0] == "null" && op == NEQ:
+		op, value = NOTNULL, nil
+	}
+	if err := allow.check(name, op); err != nil {
+		return nil, fmt.Errorf("%w in condition [%s]", err, cond)
+	}
+	return func(opts *MatchOptions, schema Schema) {
+		opts.oper(schema.Field(name), op, value)
+	}, nil
+}
+
+func isQueryField(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// splitQuery split s by the sep which is neither escaped nor quoted, the parts are kept escaped
+func splitQuery(s string, sep byte) ([]string, error) {
+	var parts []string
+	start, quoted := 0, false
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\' && i+1 == len(s):
+			return nil, fmt.Errorf("%w: trailing backslash in [%s]", ErrInvalidFilter, s)
+		case c == '\\':
+			i++
+		case c == '"':
+			quoted = !quoted
+		case c == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	if quoted {
+		return nil, fmt.Errorf("%w: unterminated quote in [%s]", ErrInvalidFilter, s)
+	}
+	return append(parts, s[start:]), nil
+}
+
+// parseQueryValue unescape the value, the unquoted values without escapes are typed,
+// the value of like becomes the pattern where the unescaped * is the wildcard
+func parseQueryValue(s string, like bool) (any, error) {
+	if strings.HasPrefix(s, `"`) {
+		if end := closingQuote(s); end != len(s)-1 {
+			return nil, fmt.Errorf("%w: text around the quoted value [%s]", ErrInvalidFilter, s)
+		}
+		text := unescapeQuery(s[1 : len(s)-1])
+		if like {
+			return EscapeLike(text), nil
+		}
+		return text, nil
+	}
+	if like {
+		var pattern strings.Builder
+		for i := 0; i < len(s); i++ {
+			switch {
+			case s[i] == '\\' && i+1 < len(s):
+				i++
+				pattern.WriteString(EscapeLike(s[i : i+1]))
+			case s[i] == '*':
+				pattern.WriteByte('%')
+			default:
+				pattern.WriteString(EscapeLike(s[i : i+1]))
+			}
+		}
+		return pattern.String(), nil
+	}
+	if strings.Contains(s, `\`) {
+		return unescapeQuery(s), nil
+	}
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return i, nil
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f, nil
+	}
+	if s == "true" || s == "false" {
+		return s == "true", nil
+	}
+	return s, nil
+}
+
+// closingQuote find the quote closing the one s starts with, -1 if there is none
+func closingQuote(s string) int {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
+
+func unescapeQuery(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// FormatQuery render the options into the url query ParseQuery accepts, only the
+// comparisons joined by AND can be rendered, the others report ErrUnsupported
+// usage:
+//
+//	query, err := FormatQuery(func(opts *MatchOptions, schema Schema) {
+//		opts.GTE(schema.Field("age"), 18).SetSort("created_at DESC").SetLimit(20)
+//	})
+//	next := "/users?" + query.Encode()
+func FormatQuery(opts ...MatchOption) (url.Values, error) {
+	opt := MatchOptions{schema: plainSchema{}}
+	opt.Apply(opts...)
+	if len(opt.Order) > 0 {
+		return nil, fmt.Errorf("%w: render the order by expressions into query", ErrUnsupported)
+	}
+	if opt.Cursor != nil {
+		return nil, fmt.Errorf("%w: render the cursor into query", ErrUnsupported)
+	}
+	if opt.Trashed != TrashedExcluded || opt.DeletedSince != nil {
+		return nil, fmt.Errorf("%w: render the trashed scope into query", ErrUnsupported)
+	}
+	query := url.Values{}
+	var conds []string
+	for _, m := range opt.Matches {
+		cond, err := formatCondition(m)
+		if err != nil {
+			return nil, err
+		}
+		conds = append(conds, cond)
+	}
+	if len(conds) > 0 {
+		query.Set("filter", strings.Join(conds, ","))
+	}
+	var sorts []string
+	for _, s := range opt.Sort {
+		for _, item := range strings.Split(s, ",") {
+			parts := strings.Fields(item)
+			switch {
+			case len(parts) == 1, len(parts) == 2 && strings.EqualFold(parts[1], "ASC"):
+				sorts = append(sorts, parts[0])
+			case len(parts) == 2 && strings.EqualFold(parts[1], "DESC"):
+				sorts = append(sorts, "-"+parts[0])
+			default:
+				return nil, fmt.Errorf("%w: render sort [%s] into query", ErrUnsupported, item)
+			}
+		}
+	}
+	if len(sorts) > 0 {
+		query.Set("sort", strings.Join(sorts, ","))
+	}
+	if opt.Limit != nil {
+		query.Set("limit", strconv.Itoa(*opt.Limit))
+	}
+	if opt.Offset != nil {
+		query.Set("offset", strconv.Itoa(*opt.Offset))
+	}
+	return query, nil
+}
+
+func formatCondition(m MatchItem) (string, error) {
+	unsupported := fmt.Errorf("%w: render operator %d on [%s] into query", ErrUnsupported, m.Operator, m.Field)
+	switch m.Operator {
+	case NULL:
+		return m.Field + "=null", nil
+	case NOTNULL:
+		return m.Field + "!=null", nil
+	case LIKE, NOTLIKE:
+		pattern, ok := m.Value.(string)
+		if !ok {
+			return "", unsupported
+		}
+		value, err := formatPattern(pattern)
+		if err != nil {
+			return "", err
+		}
+		if m.Operator == LIKE {
+			return m.Field + "~" + value, nil
+		}
+		return m.Field + "!~" + value, nil
+	case IN, NOTIN:
+		rv := reflect.ValueOf(m.Value)
+		if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array || rv.Len() < 2 {
+			// a single value can't be told from the comparison
+			return "", unsupported
+		}
+		var pieces []string
+		for i := 0; i < rv.Len(); i++ {
+			piece, err := formatQueryValue(rv.Index(i).Interface())
+			if err != nil {
+				return "", err
+			}
+			pieces = append(pieces, piece)
+		}
+		if m.Operator == IN {
+			return m.Field + "=" + strings.Join(pieces, "|"), nil
+		}
+		return m.Field + "!=" + strings.Join(pieces, "|"), nil
+	}
+	for _, o := range queryOperators {
+		if o.op == m.Operator && o.op != LIKE && o.op != NOTLIKE {
+			value, err := formatQueryValue(m.Value)
+			if err != nil {
+				return "", err
+			}
+			return m.Field + o.token + value, nil
+		}
+	}
+	return "", unsupported
+}
+
+// formatQueryValue render the value, the strings which would be typed are quoted
+func formatQueryValue(v any) (string, error) {
+	switch vl := memValue(v).(type) {
+	case int64:
+		return strconv.FormatInt(vl, 10), nil
+	case float64:
+		return strconv.FormatFloat(vl, 'g', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(vl), nil
+	case string:
+		if typed, _ := parseQueryValue(vl, false); typed != vl || vl == "null" || vl == "" || strings.ContainsAny(vl, `"`) {
+			return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(vl) + `"`, nil
+		}
+		return strings.NewReplacer(`\`, `\\`, `,`, `\,`, `|`, `\|`).Replace(vl), nil
+	}
+	return "", fmt.Errorf("%w: render %T into query", ErrUnsupported, v)
+}
+
+// formatPattern turn the like pattern into the value of ~, _ has no counterpart
+func formatPattern(pattern string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case c == '\\' && i+1 < len(pattern):
+			i++
+			c = pattern[i]
+		case c == '%':
+			b.WriteByte('*')
+			continue
+		case c == '_':
+			return "", fmt.Errorf("%w: render the wildcard _ of [%s] into query", ErrUnsupported, pattern)
+		}
+		if strings.IndexByte(`\,|*"`, c) >= 0 {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(c)
+	}
+	return b.String(), nil
+}
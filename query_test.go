@@ -0,0 +1,107 @@
+package repository
+
+import (
+	"context"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseQuery(t *testing.T) {
+	query, err := url.ParseQuery(`filter=age>=18,name~jo*,id=1|2,nick=null&sort=-age,id&limit=20&offset=40`)
+	assert.Nil(t, err)
+	opts, err := ParseQuery(query, AllowList{"name": nil, "age": nil, "id": nil, "nick": nil})
+	assert.Nil(t, err)
+	assert.Equal(t, Sum(func(opts *MatchOptions, schema Schema) {
+		opts.GTE("age", int64(18)).LIKE("name", "jo%").IN("id", []any{int64(1), int64(2)}).Null("nick").
+			SetSort("age DESC", "id").SetLimit(20).SetOffset(40)
+	}), Sum(opts...))
+	var o MatchOptions
+	o.schema = plainSchema{table: "users"}
+	o.Apply(opts...)
+	assert.Equal(t, "users.age", o.Matches[0].Field)
+}
+
+func TestParseQuery_Values(t *testing.T) {
+	for filter, expected := range map[string]MatchItem{
+		`name="007"`:        {Field: "name", Operator: EQ, Value: "007"},
+		`name=a\,b`:         {Field: "name", Operator: EQ, Value: "a,b"},
+		`name="a,b|c"`:      {Field: "name", Operator: EQ, Value: "a,b|c"},
+		`age<1.5`:           {Field: "age", Operator: LT, Value: 1.5},
+		`ok!=true`:          {Field: "ok", Operator: NEQ, Value: true},
+		`name!=null`:        {Field: "name", Operator: NOTNULL},
+		`name="null"`:       {Field: "name", Operator: EQ, Value: "null"},
+		`name!~*50\*_off%*`: {Field: "name", Operator: NOTLIKE, Value: `%50*\_off\%%`},
+		`name~"a*"`:         {Field: "name", Operator: LIKE, Value: "a*"},
+		`id!=1|"2"`:         {Field: "id", Operator: NOTIN, Value: []any{int64(1), "2"}},
+	} {
+		opts, err := ParseQuery(url.Values{"filter": {filter}}, AllowList{"name": nil, "age": nil, "ok": nil, "id": nil})
+		assert.Nil(t, err, filter)
+		o := MatchOptions{schema: plainSchema{}}
+		o.Apply(opts...)
+		assert.Equal(t, []MatchItem{expected}, o.Matches, filter)
+	}
+}
+
+func TestParseQuery_Invalid(t *testing.T) {
+	for _, query := range []url.Values{
+		{"filter": {"nick=a"}},
+		{"filter": {"name>a"}},
+		{"filter": {"name"}},
+		{"filter": {"=a"}},
+		{"filter": {"name^a"}},
+		{"filter": {"age>1|2"}},
+		{"filter": {`name="a`}},
+		{"filter": {`name="a"b`}},
+		{"filter": {`name=a\`}},
+		{"sort": {"-nick"}},
+		{"limit": {"ten"}},
+		{"offset": {"-1"}},
+	} {
+		_, err := ParseQuery(query, AllowList{"name": {EQ, LIKE}, "age": nil})
+		assert.ErrorIs(t, err, ErrInvalidFilter, query.Encode())
+	}
+}
+
+func TestFormatQuery(t *testing.T) {
+	opts := []MatchOption{func(opts *MatchOptions, schema Schema) {
+		opts.GTE(schema.Field("age"), 18).
+			StartsWith(schema.Field("name"), "jo*").
+			IN(schema.Field("id"), []int{1, 2}).
+			NEQ(schema.Field("nick"), "12").
+			EQ(schema.Field("title"), "a,b").
+			NotNull(schema.Field("deleted_at")).
+			SetSort("created_at DESC", "id").SetLimit(20)
+	}}
+	query, err := FormatQuery(opts...)
+	assert.Nil(t, err)
+	assert.Equal(t, url.Values{
+		"filter": {`age>=18,name~jo\**,id=1|2,nick!="12",title=a\,b,deleted_at!=null`},
+		"sort":   {"-created_at,id"},
+		"limit":  {"20"},
+	}, query)
+	parsed, err := ParseQuery(query, AllowList{"age": nil, "name": nil, "id": nil, "nick": nil, "title": nil, "deleted_at": nil, "created_at": nil})
+	assert.Nil(t, err)
+	assert.Equal(t, Sum(opts...), Sum(parsed...))
+	_, err = FormatQuery(Or(func(opts *MatchOptions, schema Schema) { opts.EQ("a", 1) }))
+	assert.ErrorIs(t, err, ErrUnsupported)
+	_, err = FormatQuery(func(opts *MatchOptions, schema Schema) { opts.EQ("a", 1).SetCursor("", "id") })
+	assert.ErrorIs(t, err, ErrUnsupported)
+	_, err = FormatQuery(func(opts *MatchOptions, schema Schema) { opts.EQ("a", 1).WithTrashed() })
+	assert.ErrorIs(t, err, ErrUnsupported)
+	_, err = FormatQuery(func(opts *MatchOptions, schema Schema) { opts.LIKE("a", "a_") })
+	assert.ErrorIs(t, err, ErrUnsupported)
+}
+
+func TestMemoryRepository_ParseQuery(t *testing.T) {
+	repo := NewMemory(&Member{})
+	ctx := context.Background()
+	assert.Nil(t, repo.Create(ctx, []*Member{{Name: "john", Age: 10}, {Name: "joe", Age: 20}, {Name: "ann", Age: 30}}))
+	opts, err := ParseQuery(url.Values{"filter": {"age>=18,name~jo*"}, "sort": {"-age"}}, AllowList{"name": nil, "age": nil})
+	assert.Nil(t, err)
+	var members []Member
+	assert.Nil(t, repo.Find(ctx, &members, opts...))
+	assert.Equal(t, 1, len(members))
+	assert.Equal(t, "joe", members[0].Name)
+}
@@ -3,7 +3,6 @@ package repository
 import (
 	"context"
 	"errors"
-	"fmt"
 	"time"
 )
 
@@ -11,8 +10,38 @@ type Operator int
 
 var (
 	ErrRecordNotFound = errors.New("record not found")
+	ErrDuplicatedKey  = errors.New("duplicated key")
+	ErrUnsupported    = errors.New("unsupported")
+	ErrCanceled       = errors.New("canceled")
+	// ErrStaleRecord is returned when the version of the record is changed by others, see the version tag
+	ErrStaleRecord = errors.New("stale record")
 )
 
+type canceledError struct {
+	err error
+}
+
+func (e *canceledError) Error() string {
+	return ErrCanceled.Error() + ": " + e.err.Error()
+}
+
+func (e *canceledError) Is(target error) bool {
+	return target == ErrCanceled
+}
+
+func (e *canceledError) Unwrap() error {
+	return e.err
+}
+
+// contextError surface the cancellation of ctx as ErrCanceled, the cause is kept
+// so errors.Is(err, context.DeadlineExceeded) still works
+func contextError(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return &canceledError{err: err}
+	}
+	return nil
+}
+
 const (
 	EQ Operator = iota
 	NEQ
@@ -32,6 +61,21 @@ const (
 
 	LIKE
 
+	EXISTS
+	NOTEXISTS
+
+	BETWEEN
+	NOTBETWEEN
+	NOTLIKE
+	ILIKE
+	REGEXP
+
+	ALL
+	ANY
+	NOT
+
+	EXPR
+
 	LeftJoin  = 0
 	InnerJoin = 1
 	RightJoin = 2
@@ -53,6 +97,8 @@ type field struct {
 type Func struct {
 	Template string
 	Field    []any
+	// Dialects override the Template for the dialects, keyed by the name of the dialector
+	Dialects map[string]string
 }
 
 func Field(fld any) field {
@@ -74,16 +120,15 @@ func (f field) AS(as string) field {
 	return f
 }
 
+// String render the field for the dialect of the schema, see Func.String
 func (f *field) String(schema Schema) string {
-	var ret string
-	switch vl := f.Field.(type) {
-	case Func:
-		ret = vl.String(schema)
-	case field:
-		ret = vl.String(schema)
-	case string:
-		ret = schema.Quote(vl)
-	}
+	return render(schema, func(repo *dbrepo) (string, []any, error) {
+		return repo.compileField(schema, *f)
+	})
+}
+
+// decorate append the alias or the direction to the compiled field
+func (f *field) decorate(ret string) string {
 	if f.as != "" {
 		ret += " AS " + f.as
 		return ret
@@ -95,19 +140,12 @@ func (f *field) String(schema Schema) string {
 	return ret
 }
 
+// String render the function for the dialect of the schema with the values inlined, it's meant
+// for logs, the repositories bind the values instead. the error of compiling is rendered as %!(ERROR=...)
 func (f Func) String(schema Schema) string {
-	var args []any
-	for _, fi := range f.Field {
-		switch vl := fi.(type) {
-		case Func:
-			args = append(args, vl.String(schema))
-		case field:
-			args = append(args, vl.String(schema))
-		case string:
-			args = append(args, vl)
-		}
-	}
-	return fmt.Sprintf(f.Template, args...)
+	return render(schema, func(repo *dbrepo) (string, []any, error) {
+		return repo.compileFunc(schema, f)
+	})
 }
 
 type Fields map[string]any
@@ -121,6 +159,9 @@ type Join struct {
 type Group struct {
 	By     string
 	Having []MatchOption
+	// Exprs is the names, Fields and Funcs grouped by after By, the names are qualified through the Schema
+	Exprs  []any
+	Rollup bool
 }
 
 type Model struct {
@@ -129,6 +170,8 @@ type Model struct {
 	From   any
 	Joins  []Join
 	Grp    *Group
+	// aggregates is computed by Aggregate, see Into
+	aggregates []aggregateItem
 }
 
 func GetModel(result interface{}, froms ...any) *Model {
@@ -201,6 +244,11 @@ type TableSetter interface {
 	SetTable(table string)
 }
 
+// ModelNamer is implemented by the repositories which know the name of the model they manage
+type ModelNamer interface {
+	ModelName() string
+}
+
 type Repository interface {
 	// First get the first record of the records which fetched from the DB alongside the match condition
 	First(ctx context.Context, v any, opts ...MatchOption) error
@@ -1,6 +1,9 @@
 package repository
 
-import "testing"
+import (
+	"testing"
+	"time"
+)
 
 func TestSum(t *testing.T) {
 	opt := MatchOptions{}
@@ -8,7 +11,37 @@ func TestSum(t *testing.T) {
 	opt.GTE("hello", "world")
 	sum := opt.Sum()
 	t.Log(sum)
-	if sum != "0ca96518fe5e365852b88890962a1a6a" {
+	if sum != "66b806aeca2eba8d79880d59cc597225" {
 		t.Fatal("sum error")
 	}
 }
+
+func TestSum_nested(t *testing.T) {
+	if Sum(OrLike("hello")) == Sum(OrLike("world")) {
+		t.Fatal("nested options with different values have the same sum")
+	}
+	if Sum(OrLike("hello")) != Sum(OrLike("hello")) {
+		t.Fatal("nested options with the same values have different sums")
+	}
+	limit := func(opts *MatchOptions, schema Schema) { opts.SetSort("a1").SetLimit(2) }
+	sort := func(opts *MatchOptions, schema Schema) { opts.SetSort("a").SetLimit(12) }
+	if Sum(limit) == Sum(sort) {
+		t.Fatal("sort and limit are ambiguous")
+	}
+}
+
+func TestEqual(t *testing.T) {
+	at := time.Date(2024, 1, 1, 8, 0, 0, 0, time.FixedZone("UTC+8", 8*3600))
+	a := func(opts *MatchOptions, schema Schema) {
+		opts.IN(schema.Field("id"), []int{1, 2}).LT("created_at", at).EQ("author_id", Field("users.id"))
+	}
+	b := func(opts *MatchOptions, schema Schema) {
+		opts.IN(schema.Field("id"), []int{1, 2}).LT("created_at", at.UTC()).EQ("author_id", Field("users.id"))
+	}
+	if !Equal([]MatchOption{a, OrLike("hello")}, []MatchOption{b, OrLike("hello")}) {
+		t.Fatal("options should be equal")
+	}
+	if Equal([]MatchOption{a}, []MatchOption{a, OrLike("hello")}) {
+		t.Fatal("options should not be equal")
+	}
+}
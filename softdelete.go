@@ -0,0 +1,170 @@
+// Copyright (c) 2024 Yang,Zhong
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+package repository
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	"gorm.io/gorm"
+	gschema "gorm.io/gorm/schema"
+)
+
+// Trashed is the scope of the soft deleted records
+type Trashed int
+
+const (
+	// TrashedExcluded is the default scope, the soft deleted records are not matched
+	TrashedExcluded Trashed = iota
+	TrashedIncluded
+	TrashedOnly
+)
+
+// WithSoftDelete make Delete set the column, "deleted_at" by default, to the current time instead of
+// removing the records. the repositories of the models with a gorm.DeletedAt field soft delete without it
+func WithSoftDelete(column ...string) Option {
+	return func(opts *options) {
+		opts.softDelete = "deleted_at"
+		if len(column) > 0 {
+			opts.softDelete = column[0]
+		}
+	}
+}
+
+// WithTrashed match the soft deleted records as well
+func (opts *MatchOptions) WithTrashed() *MatchOptions {
+	opts.Trashed = TrashedIncluded
+	return opts
+}
+
+// OnlyTrashed match the soft deleted records only
+func (opts *MatchOptions) OnlyTrashed() *MatchOptions {
+	opts.Trashed = TrashedOnly
+	return opts
+}
+
+// DeletedAfter match the records soft deleted after t
+func (opts *MatchOptions) DeletedAfter(t time.Time) *MatchOptions {
+	opts.Trashed = TrashedOnly
+	opts.DeletedSince = &t
+	return opts
+}
+
+func withTrashed(opts *MatchOptions, schema Schema) {
+	opts.WithTrashed()
+}
+
+func onlyTrashed(opts *MatchOptions, schema Schema) {
+	opts.OnlyTrashed()
+}
+
+// scopeTrashed append the matches of the trashed scope on column, which is empty if the
+// repository doesn't soft delete
+func (opts *MatchOptions) scopeTrashed(column string) error {
+	if column == "" {
+		if opts.Trashed == TrashedOnly || opts.DeletedSince != nil {
+			return fmt.Errorf("%w: trashed records of the repository which doesn't soft delete", ErrUnsupported)
+		}
+		return nil
+	}
+	// the matches with OR are quoted, so the scope applies to all of them
+	for _, m := range opts.Matches {
+		if m.Operator == OR {
+			matches := opts.Matches
+			opts.Matches = nil
+			opts.Quote(func(opts *MatchOptions, schema Schema) {
+				opts.Matches = append(opts.Matches, matches...)
+			})
+			break
+		}
+	}
+	switch opts.Trashed {
+	case TrashedExcluded:
+		opts.Null(column)
+	case TrashedOnly:
+		opts.NotNull(column)
+	}
+	if opts.DeletedSince != nil {
+		opts.GT(column, *opts.DeletedSince)
+	}
+	return nil
+}
+
+func hasMatches(schema Schema, opts []MatchOption) bool {
+	o := MatchOptions{schema: schema}
+	o.Apply(opts...)
+	return len(o.Matches) > 0
+}
+
+var deletedAtType = reflect.TypeOf(gorm.DeletedAt{})
+
+// softDeleteField find the gorm.DeletedAt field of the model
+func softDeleteField(s *gschema.Schema) *gschema.Field {
+	for _, f := range s.Fields {
+		if f.FieldType == deletedAtType {
+			return f
+		}
+	}
+	return nil
+}
+
+// softDelete get the column of the soft delete, empty if the repository doesn't soft delete
+func (repo *dbrepo) softDelete() string {
+	if repo.options.softDelete != "" {
+		return repo.options.softDelete
+	}
+	if repo.model == nil {
+		return ""
+	}
+	if _, ok := repo.model.(string); ok {
+		return ""
+	}
+	stmt := &gorm.Statement{DB: repo.db}
+	if err := stmt.Parse(repo.model); err != nil {
+		return ""
+	}
+	if f := softDeleteField(stmt.Schema); f != nil {
+		return f.DBName
+	}
+	return ""
+}
+
+// Restore clear the deleted_at of the soft deleted records
+func (db *dbrepo) Restore(ctx context.Context, opts ...MatchOption) error {
+	ctx, cancel := db.options.context(ctx)
+	defer cancel()
+	column := db.softDelete()
+	if column == "" {
+		return fmt.Errorf("%w: restore the records of the repository which doesn't soft delete", ErrUnsupported)
+	}
+	return db.observe(ctx, "Restore", opts, func(ctx context.Context) (rows int64, err error) {
+		err = db.outbox(ctx, Restored, nil, opts, func(ctx context.Context) error {
+			restorer := db.getDB(ctx)
+			db.applyOptions(restorer, append([]MatchOption{onlyTrashed}, opts...)...)
+			tx := restorer.UpdateColumn(column, nil)
+			rows = tx.RowsAffected
+			return tx.Error
+		})
+		return rows, db.transformError(ctx, err)
+	})
+}
+
+// ForceDelete remove the records no matter they are soft deleted or not
+func (db *dbrepo) ForceDelete(ctx context.Context, opts ...MatchOption) error {
+	ctx, cancel := db.options.context(ctx)
+	defer cancel()
+	return db.observe(ctx, "ForceDelete", opts, func(ctx context.Context) (rows int64, err error) {
+		err = db.outbox(ctx, Deleted, nil, opts, func(ctx context.Context) error {
+			deletor := db.getDB(ctx)
+			db.applyOptions(deletor, append([]MatchOption{withTrashed}, opts...)...)
+			tx := deletor.Delete(db.model)
+			rows = tx.RowsAffected
+			return tx.Error
+		})
+		return rows, db.transformError(ctx, err)
+	})
+}
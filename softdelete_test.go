@@ -0,0 +1,88 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+type Post struct {
+	ID        uint
+	Title     string
+	DeletedAt gorm.DeletedAt
+}
+
+func postIDs(t *testing.T, repo RDBRepository, opts ...MatchOption) []uint {
+	var posts []Post
+	assert.Nil(t, repo.Find(context.Background(), &posts, append(opts, func(opts *MatchOptions, schema Schema) {
+		opts.SetSort("id")
+	})...))
+	var ids []uint
+	for _, p := range posts {
+		ids = append(ids, p.ID)
+	}
+	return ids
+}
+
+func testSoftDelete(t *testing.T, repo RDBRepository) {
+	ctx := context.Background()
+	id := func(id uint) MatchOption {
+		return func(opts *MatchOptions, schema Schema) { opts.EQ(schema.Field("id"), id) }
+	}
+	title := func(a, b string) MatchOption {
+		return func(opts *MatchOptions, schema Schema) {
+			opts.EQ(schema.Field("title"), a).OR(func(opts *MatchOptions, schema Schema) {
+				opts.EQ(schema.Field("title"), b)
+			})
+		}
+	}
+	assert.Nil(t, repo.Create(ctx, []*Post{{Title: "a"}, {Title: "b"}, {Title: "c"}}))
+	assert.Nil(t, repo.Delete(ctx, id(1)))
+	assert.True(t, errors.Is(repo.Delete(ctx), gorm.ErrMissingWhereClause))
+	assert.Equal(t, []uint{2, 3}, postIDs(t, repo))
+	assert.Equal(t, []uint{2}, postIDs(t, repo, title("a", "b")))
+	assert.Equal(t, []uint{1, 2, 3}, postIDs(t, repo, func(opts *MatchOptions, schema Schema) { opts.WithTrashed() }))
+	assert.Equal(t, []uint{1}, postIDs(t, repo, func(opts *MatchOptions, schema Schema) { opts.OnlyTrashed() }))
+	assert.Equal(t, []uint{1}, postIDs(t, repo, func(opts *MatchOptions, schema Schema) { opts.DeletedAfter(time.Now().Add(-time.Hour)) }))
+	assert.Empty(t, postIDs(t, repo, func(opts *MatchOptions, schema Schema) { opts.DeletedAfter(time.Now().Add(time.Hour)) }))
+	var count int64
+	assert.Nil(t, repo.Count(ctx, &count))
+	assert.Equal(t, int64(2), count)
+	assert.Nil(t, repo.UpdateFields(ctx, Fields{"title": "x"}, title("a", "c")))
+	var post Post
+	assert.Nil(t, repo.First(ctx, &post, id(1), func(opts *MatchOptions, schema Schema) { opts.WithTrashed() }))
+	assert.Equal(t, "a", post.Title)
+	assert.True(t, post.DeletedAt.Valid)
+
+	assert.Nil(t, repo.Restore(ctx, id(1)))
+	assert.Equal(t, []uint{1, 2, 3}, postIDs(t, repo))
+	assert.Nil(t, repo.Delete(ctx, id(2)))
+	assert.Nil(t, repo.ForceDelete(ctx, id(2)))
+	assert.Nil(t, repo.ForceDelete(ctx, id(3)))
+	assert.Equal(t, []uint{1}, postIDs(t, repo, func(opts *MatchOptions, schema Schema) { opts.WithTrashed() }))
+}
+
+func TestSoftDelete(t *testing.T) {
+	testSoftDelete(t, New(sqliteDB(t, &Post{}), &Post{}))
+	testSoftDelete(t, NewWithTable(sqliteDB(t, &Post{}), "posts", WithSoftDelete()))
+}
+
+func TestMemoryRepository_SoftDelete(t *testing.T) {
+	testSoftDelete(t, NewMemory(&Post{}))
+}
+
+func TestSoftDelete_disabled(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemory(&Member{})
+	assert.Nil(t, repo.Create(ctx, &Member{Name: "a"}))
+	assert.True(t, errors.Is(repo.Restore(ctx), ErrUnsupported))
+	var members []Member
+	err := repo.Find(ctx, &members, func(opts *MatchOptions, schema Schema) { opts.OnlyTrashed() })
+	assert.True(t, errors.Is(err, ErrUnsupported))
+	assert.Nil(t, repo.Find(ctx, &members, func(opts *MatchOptions, schema Schema) { opts.WithTrashed() }))
+	assert.Equal(t, 1, len(members))
+}
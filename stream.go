@@ -0,0 +1,164 @@
+// Copyright (c) 2024 Yang,Zhong
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// ErrStopIteration is returned by the fn of Each and FindInBatches to stop the iteration without error
+var ErrStopIteration = errors.New("stop iteration")
+
+// Each scan the records one by one into v and call fn after each scan, the records are
+// streamed from the rows of the query, so the connection is held until Each returns.
+// v is a pointer of struct, or a *Model whose Result is a pointer of struct
+// usage:
+//
+//	var user User
+//	err := repo.Each(ctx, &user, func() error {
+//		return w.Write(user)
+//	}, Role("member"))
+func (db *dbrepo) Each(ctx context.Context, v any, fn func() error, opts ...MatchOption) error {
+	ctx, cancel := db.options.context(ctx)
+	defer cancel()
+	return db.observe(ctx, "Each", opts, func(ctx context.Context) (int64, error) {
+		var scanned int64
+		err := db.stream(ctx, v, opts, func(rows *sql.Rows, result reflect.Value) error {
+			if result.Elem().Kind() == reflect.Slice {
+				return fmt.Errorf("each only support pointer of struct as result, got %s", result.Type())
+			}
+			result.Elem().Set(reflect.Zero(result.Elem().Type()))
+			if err := db.conn(ctx).ScanRows(rows, result.Interface()); err != nil {
+				return err
+			}
+			scanned++
+			return fn()
+		})
+		if errors.Is(err, ErrStopIteration) {
+			err = nil
+		}
+		return scanned, db.transformError(ctx, err)
+	})
+}
+
+// FindInBatches fill v with at most size records and call fn after each batch, the records
+// are streamed like Each does. v is a pointer of slice, or a *Model whose Result is a pointer
+// of slice, a new slice is set to it for every batch
+// usage:
+//
+//	var users []User
+//	err := repo.FindInBatches(ctx, &users, 1000, func() error {
+//		return index(users)
+//	}, Role("member"))
+func (db *dbrepo) FindInBatches(ctx context.Context, v any, size int, fn func() error, opts ...MatchOption) error {
+	ctx, cancel := db.options.context(ctx)
+	defer cancel()
+	if size <= 0 {
+		return fmt.Errorf("find in batches requires a positive size, got %d", size)
+	}
+	return db.observe(ctx, "FindInBatches", opts, func(ctx context.Context) (int64, error) {
+		var batch *batcher
+		var scanned int64
+		err := db.stream(ctx, v, opts, func(rows *sql.Rows, result reflect.Value) error {
+			if batch == nil {
+				var err error
+				if batch, err = newBatcher(result, size, fn); err != nil {
+					return err
+				}
+			}
+			elem := batch.elem()
+			if err := db.conn(ctx).ScanRows(rows, elem.Interface()); err != nil {
+				return err
+			}
+			scanned++
+			return batch.add(elem)
+		})
+		if err == nil && batch != nil {
+			err = batch.flush()
+		}
+		if errors.Is(err, ErrStopIteration) {
+			err = nil
+		}
+		return scanned, db.transformError(ctx, err)
+	})
+}
+
+// stream run the query of v and call each for every row
+func (db *dbrepo) stream(ctx context.Context, v any, opts []MatchOption, each func(rows *sql.Rows, result reflect.Value) error) error {
+	selector, result := db.prepare(ctx, v)
+	rv := reflect.ValueOf(result)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("result must be a non-nil pointer, got %T", result)
+	}
+	db.applyOptions(selector, opts...)
+	rows, err := selector.Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := each(rows, rv); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// batcher collect the records into the slice and hand them to fn every size records
+type batcher struct {
+	slice reflect.Value
+	elemT reflect.Type
+	isPtr bool
+	size  int
+	fn    func() error
+}
+
+func newBatcher(result reflect.Value, size int, fn func() error) (*batcher, error) {
+	if result.Kind() != reflect.Ptr || result.Elem().Kind() != reflect.Slice {
+		return nil, fmt.Errorf("find in batches only support pointer of slice as result, got %s", result.Type())
+	}
+	b := &batcher{slice: result.Elem(), elemT: result.Elem().Type().Elem(), size: size, fn: fn}
+	if b.elemT.Kind() == reflect.Ptr {
+		b.elemT, b.isPtr = b.elemT.Elem(), true
+	}
+	b.reset()
+	return b, nil
+}
+
+// elem allocate a pointer of the element
+func (b *batcher) elem() reflect.Value {
+	return reflect.New(b.elemT)
+}
+
+func (b *batcher) add(elem reflect.Value) error {
+	if !b.isPtr {
+		elem = elem.Elem()
+	}
+	b.slice.Set(reflect.Append(b.slice, elem))
+	if b.slice.Len() < b.size {
+		return nil
+	}
+	return b.flush()
+}
+
+func (b *batcher) flush() error {
+	if b.slice.Len() == 0 {
+		return nil
+	}
+	if err := b.fn(); err != nil {
+		return err
+	}
+	b.reset()
+	return nil
+}
+
+// reset set a new slice, so the slice of the last batch is safe to keep
+func (b *batcher) reset() {
+	b.slice.Set(reflect.MakeSlice(b.slice.Type(), 0, b.size))
+}
@@ -0,0 +1,83 @@
+package repository
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testStream(t *testing.T, repo RDBRepository) {
+	ctx := context.Background()
+	assert.Nil(t, repo.Create(ctx, []*Member{{Age: 30}, {Age: 20}, {Age: 20}, {Age: 10}, {Age: 40}}))
+	sort := func(opts *MatchOptions, schema Schema) {
+		opts.GT(schema.Field("age"), 10).SetSort("age DESC", "id")
+	}
+	var member Member
+	var seen []uint
+	assert.Nil(t, repo.Each(ctx, &member, func() error {
+		seen = append(seen, member.ID)
+		return nil
+	}, sort))
+	assert.Equal(t, []uint{5, 1, 2, 3}, seen)
+	seen = nil
+	assert.Nil(t, repo.Each(ctx, &member, func() error {
+		seen = append(seen, member.ID)
+		if len(seen) == 2 {
+			return ErrStopIteration
+		}
+		return nil
+	}, sort))
+	assert.Equal(t, []uint{5, 1}, seen)
+
+	var members []*Member
+	var batches [][]*Member
+	assert.Nil(t, repo.FindInBatches(ctx, &members, 2, func() error {
+		batches = append(batches, members)
+		return nil
+	}))
+	assert.Equal(t, 3, len(batches))
+	assert.Equal(t, []uint{1, 2}, []uint{batches[0][0].ID, batches[0][1].ID})
+	assert.Equal(t, []uint{3, 4}, []uint{batches[1][0].ID, batches[1][1].ID})
+	assert.Equal(t, uint(5), batches[2][0].ID)
+	batches = nil
+	assert.Nil(t, repo.FindInBatches(ctx, &members, 3, func() error {
+		batches = append(batches, members)
+		return ErrStopIteration
+	}, sort))
+	assert.Equal(t, 1, len(batches))
+	assert.Equal(t, 3, len(batches[0]))
+	assert.NotNil(t, repo.FindInBatches(ctx, &member, 3, func() error { return nil }))
+}
+
+func TestStream(t *testing.T) {
+	testStream(t, New(sqliteDB(t, &Member{}), &Member{}))
+}
+
+func TestMemoryRepository_Stream(t *testing.T) {
+	testStream(t, NewMemory(&Member{}))
+}
+
+func TestStream_model(t *testing.T) {
+	ctx := context.Background()
+	db := sqliteDB(t, &User{}, &Book{})
+	assert.Nil(t, New(db, &User{}).Create(ctx, []*User{{ID: "1", Name: "a"}, {ID: "2", Name: "b"}}))
+	repo := New(db, &Book{})
+	assert.Nil(t, repo.Create(ctx, []*Book{{ID: "1", AuthorID: "1"}, {ID: "2", AuthorID: "2"}}))
+	var book BookWithUserWithoutFromField
+	var authors []string
+	assert.Nil(t, repo.Each(ctx, GetModel(&book, &Book{}).With(&User{}, AuthorID(Field("users.id"))), func() error {
+		authors = append(authors, book.AuthorName)
+		return nil
+	}, func(opts *MatchOptions, schema Schema) { opts.SetSort("books.id") }))
+	assert.Equal(t, []string{"a", "b"}, authors)
+	var books []BookWithUserWithoutFromField
+	assert.Nil(t, repo.FindInBatches(ctx, GetModel(&books, &Book{}).With(&User{}, AuthorID(Field("users.id"))), 10, func() error {
+		authors = nil
+		for _, b := range books {
+			authors = append(authors, b.AuthorName)
+		}
+		return nil
+	}, func(opts *MatchOptions, schema Schema) { opts.SetSort("books.id DESC") }))
+	assert.Equal(t, []string{"b", "a"}, authors)
+}
@@ -0,0 +1,96 @@
+// Copyright (c) 2024 Yang,Zhong
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// Subquery is a query which is used as the value of the match item or the operand of EXISTS
+type Subquery struct {
+	Model *Model
+	Opts  []MatchOption
+}
+
+// Sub build a subquery from the model, the fields of the model are selected, all the
+// fields are selected if none is given
+// usage:
+//
+//	// the books of the authors named like hello
+//	opts.IN(schema.Field("author_id"), Sub(GetModel(nil, &User{}).Fields("id"), Like("hello")))
+//	// the users who wrote a book
+//	opts.Exists(Sub(GetModel(nil, &Book{}), AuthorID(Field("users.id"))))
+func Sub(model *Model, opts ...MatchOption) Subquery {
+	return Subquery{Model: model, Opts: opts}
+}
+
+func (opts *MatchOptions) Exists(sub Subquery) *MatchOptions {
+	return opts.oper("", EXISTS, sub)
+}
+
+func (opts *MatchOptions) NotExists(sub Subquery) *MatchOptions {
+	return opts.oper("", NOTEXISTS, sub)
+}
+
+// subquery compile the subquery by a repository of its model, so the fields are resolved
+// against the model and the scopes of the model apply. the options of the repository are
+// not inherited, the soft delete of the subquery only comes from its model
+func (repo *dbrepo) subquery(sub Subquery) (*gorm.DB, error) {
+	if sub.Model == nil {
+		return nil, errors.New("subquery requires a model")
+	}
+	if repo.db == nil {
+		return nil, errors.New("subquery requires a database")
+	}
+	subrepo := &dbrepo{db: repo.db, model: sub.Model.From}
+	if table, ok := sub.Model.From.(string); ok {
+		subrepo.table = table
+	}
+	selector, _ := subrepo.prepare(context.Background(), sub.Model)
+	subrepo.applyOptions(selector, sub.Opts...)
+	return selector, selector.Error
+}
+
+// compileItem compile the item which is neither NULL, NOTNULL nor a group of options
+func (repo *dbrepo) compileItem(schema Schema, item MatchItem) (string, []any, error) {
+	switch item.Operator {
+	case EXISTS, NOTEXISTS:
+		sub, _ := item.Value.(Subquery)
+		selector, err := repo.subquery(sub)
+		if err != nil {
+			return "", nil, err
+		}
+		if item.Operator == NOTEXISTS {
+			return "NOT EXISTS (?)", []any{selector}, nil
+		}
+		return "EXISTS (?)", []any{selector}, nil
+	case BETWEEN, NOTBETWEEN:
+		low, high, err := betweenBounds(item.Value)
+		if err != nil {
+			return "", nil, err
+		}
+		return fmt.Sprintf("%s %s ? AND ?", item.Field, operatorMap[item.Operator]), []any{low, high}, nil
+	}
+	left, op, right := item.Field, operatorMap[item.Operator], "?"
+	values := []any{item.Value}
+	switch vl := item.Value.(type) {
+	case field:
+		var err error
+		if right, values, err = repo.compileField(schema, vl); err != nil {
+			return "", nil, err
+		}
+	case Subquery:
+		selector, err := repo.subquery(vl)
+		if err != nil {
+			return "", nil, err
+		}
+		right, values = "(?)", []any{selector}
+	}
+	return repo.dialectOperator(left, item.Operator, op, right), values, nil
+}
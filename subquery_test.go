@@ -0,0 +1,99 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestGormRepository_Subquery(t *testing.T) {
+	db, mock, err := sqlmock.New() // mock sql.DB
+	assert.Nil(t, err)
+	defer db.Close()
+	defer assert.Nil(t, mock.ExpectationsWereMet())
+	gdb, err := gorm.Open(dialector(db)) // open gorm db
+	assert.Nil(t, err)
+	repo := New(gdb, &Book{})
+	func() {
+		execSql := "^SELECT \\* FROM `books` WHERE `books`.`name` = \\? AND `books`.`author_id` IN \\(SELECT `id` FROM `users` WHERE users.name LIKE \\?\\) AND \\(`books`.`id` = \\? OR NOT EXISTS \\(SELECT \\* FROM `users` WHERE `users`.`id` = `books`.`author_id` AND `users`.`name` = \\?\\)\\)$"
+		mock.ExpectQuery(execSql).
+			WithArgs("x", "%a%", "1", "b").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "author_id"}))
+	}()
+	var books []Book
+	err = repo.Find(context.Background(), &books, func(opts *MatchOptions, schema Schema) {
+		opts.EQ(schema.Field("name"), "x").
+			IN(schema.Field("author_id"), Sub(GetModel(nil, &User{}).Fields("id"), Like("a"))).
+			Quote(func(opts *MatchOptions, schema Schema) {
+				opts.EQ(schema.Field("id"), "1").OR(func(opts *MatchOptions, schema Schema) {
+					opts.NotExists(Sub(GetModel(nil, &User{}), func(opts *MatchOptions, schema Schema) {
+						opts.EQ(schema.Field("id"), Field("books.author_id")).EQ(schema.Field("name"), "b")
+					}))
+				})
+			})
+	})
+	assert.Nil(t, err)
+}
+
+func TestSubquery(t *testing.T) {
+	ctx := context.Background()
+	db := sqliteDB(t, &User{}, &Book{})
+	users := New(db, &User{})
+	assert.Nil(t, users.Create(ctx, []*User{{ID: "1", Name: "alice"}, {ID: "2", Name: "bob"}, {ID: "3", Name: "carol"}}))
+	assert.Nil(t, New(db, &Book{}).Create(ctx, []*Book{{ID: "1", AuthorID: "1"}, {ID: "2", AuthorID: "2"}}))
+	var found []User
+	assert.Nil(t, users.Find(ctx, &found, func(opts *MatchOptions, schema Schema) {
+		opts.Exists(Sub(GetModel(nil, &Book{}), AuthorID(Field("users.id")))).NEQ(schema.Field("name"), "bob")
+	}))
+	assert.Equal(t, []User{{ID: "1", Name: "alice"}}, found)
+	found = nil
+	assert.Nil(t, users.Find(ctx, &found, func(opts *MatchOptions, schema Schema) {
+		opts.NotIN(schema.Field("id"), Sub(GetModel(nil, &Book{}).Fields("author_id"))).SetSort("id")
+	}))
+	assert.Equal(t, []User{{ID: "3", Name: "carol"}}, found)
+
+	err := NewMemory(&User{}).Find(ctx, &found, func(opts *MatchOptions, schema Schema) {
+		opts.Exists(Sub(GetModel(nil, &Book{})))
+	})
+	assert.True(t, errors.Is(err, ErrUnsupported))
+}
+
+func TestSubquery_WithoutModel(t *testing.T) {
+	ctx := context.Background()
+	repo := New(sqliteDB(t, &User{}), &User{})
+	var found []User
+	err := repo.Find(ctx, &found, func(opts *MatchOptions, schema Schema) {
+		opts.Exists(Sub(nil))
+	})
+	assert.EqualError(t, err, "subquery requires a model")
+	var count int64
+	err = repo.Count(ctx, &count, func(opts *MatchOptions, schema Schema) {
+		opts.EQ(schema.Field("name"), "alice").OR(func(opts *MatchOptions, schema Schema) {
+			opts.IN(schema.Field("id"), Sub(nil))
+		})
+	})
+	assert.EqualError(t, err, "subquery requires a model")
+}
+
+func TestSubquery_SoftDelete(t *testing.T) {
+	ctx := context.Background()
+	db := sqliteDB(t, &Post{}, &User{})
+	posts := NewWithTable(db, "posts", WithSoftDelete())
+	assert.Nil(t, posts.Create(ctx, []*Post{{Title: "alice"}, {Title: "bob"}, {Title: "carol"}}))
+	assert.Nil(t, New(db, &User{}).Create(ctx, []*User{{ID: "1", Name: "alice"}, {ID: "2", Name: "bob"}}))
+	assert.Nil(t, posts.Delete(ctx, func(opts *MatchOptions, schema Schema) { opts.EQ(schema.Field("id"), 1) }))
+	// the subquery on users doesn't inherit the soft delete column of posts
+	assert.Equal(t, []uint{2}, postIDs(t, posts, func(opts *MatchOptions, schema Schema) {
+		opts.Exists(Sub(GetModel(nil, &User{}), func(opts *MatchOptions, schema Schema) {
+			opts.EQ(schema.Field("name"), Field("posts.title"))
+		}))
+	}))
+	// the subquery on a soft deleted model is scoped by its own gorm.DeletedAt field
+	assert.Equal(t, []uint{1}, postIDs(t, posts, func(opts *MatchOptions, schema Schema) {
+		opts.WithTrashed().NotIN(schema.Field("title"), Sub(GetModel(nil, &Post{}).Fields("title")))
+	}))
+}
@@ -0,0 +1,65 @@
+// Copyright (c) 2024 Yang,Zhong
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// Operation is the call of the repository ToSQL compiles
+type Operation int
+
+const (
+	OpFirst Operation = iota
+	OpFind
+	OpCount
+	OpDelete
+	OpUpdateFields
+)
+
+// SQLCompiler is implemented by the repositories which talk to the db with sql
+type SQLCompiler interface {
+	// ToSQL compile the call without executing it
+	ToSQL(op Operation, v any, opts ...MatchOption) (string, []any, error)
+}
+
+var _ SQLCompiler = &dbrepo{}
+
+// ToSQL compile the call of op into the sql and the bind args with the dry run session of gorm,
+// nothing is executed and no change event is stored. v is the argument the call takes, the
+// result of First, Find and Count, the Fields of UpdateFields, and nil for Delete
+// usage:
+//
+//	sql, args, err := repo.(SQLCompiler).ToSQL(OpFind, GetModel(&books, &Book{}).With(&User{}, joinUser), opts...)
+func (db *dbrepo) ToSQL(op Operation, v any, opts ...MatchOption) (string, []any, error) {
+	dry := &dbrepo{db: db.db.Session(&gorm.Session{DryRun: true, SkipDefaultTransaction: true}), table: db.table, model: db.model, options: db.options}
+	ctx := context.Background()
+	var tx *gorm.DB
+	switch op {
+	case OpFirst:
+		tx = dry.first(ctx, v, opts)
+	case OpFind:
+		tx = dry.find(ctx, v, opts)
+	case OpCount:
+		tx = dry.count(ctx, v, opts)
+	case OpDelete:
+		tx = dry.delete(ctx, opts)
+	case OpUpdateFields:
+		fields, ok := v.(Fields)
+		if !ok {
+			return "", nil, fmt.Errorf("update fields requires Fields, got %T", v)
+		}
+		tx, _ = dry.updateFields(ctx, fields, opts)
+	default:
+		return "", nil, fmt.Errorf("%w: operation %d", ErrUnsupported, op)
+	}
+	if tx.Error != nil {
+		return "", nil, db.transformError(ctx, tx.Error)
+	}
+	return tx.Statement.SQL.String(), tx.Statement.Vars, nil
+}
@@ -0,0 +1,64 @@
+package repository
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestToSQL(t *testing.T) {
+	db, mock, err := sqlmock.New() // mock sql.DB
+	assert.Nil(t, err)
+	defer db.Close()
+	defer assert.Nil(t, mock.ExpectationsWereMet())
+	gdb, err := gorm.Open(dialector(db)) // open gorm db
+	assert.Nil(t, err)
+	repo := New(gdb, &Book{}, WithOutbox()).(SQLCompiler)
+	byAuthor := func(opts *MatchOptions, schema Schema) { opts.IN(schema.Field("author_id"), []string{"1", "2"}) }
+
+	var books []Book
+	sql, args, err := repo.ToSQL(OpFind, GetModel(&books, &Book{}).With(&User{}, func(opts *MatchOptions, schema Schema) {
+		opts.EQ("users.id", Field("books.author_id"))
+	}), byAuthor, func(opts *MatchOptions, schema Schema) { opts.SetLimit(10) })
+	assert.Nil(t, err)
+	assert.Equal(t, "SELECT `books`.`id`,`books`.`name`,`books`.`author_id` FROM `books` LEFT JOIN users ON users.id = `books`.`author_id` WHERE `books`.`author_id` IN (?,?) LIMIT 10", sql)
+	assert.Equal(t, []any{"1", "2"}, args)
+
+	var book Book
+	sql, args, err = repo.ToSQL(OpFirst, &book, byAuthor)
+	assert.Nil(t, err)
+	assert.Equal(t, "SELECT * FROM `books` WHERE `books`.`author_id` IN (?,?) ORDER BY `books`.`id` LIMIT 1", sql)
+	assert.Equal(t, []any{"1", "2"}, args)
+
+	var count int64
+	sql, _, err = repo.ToSQL(OpCount, &count, byAuthor)
+	assert.Nil(t, err)
+	assert.Equal(t, "SELECT count(*) FROM `books` WHERE `books`.`author_id` IN (?,?)", sql)
+
+	sql, args, err = repo.ToSQL(OpDelete, nil, byAuthor)
+	assert.Nil(t, err)
+	assert.Equal(t, "DELETE FROM `books` WHERE `books`.`author_id` IN (?,?)", sql)
+	assert.Equal(t, []any{"1", "2"}, args)
+
+	sql, args, err = repo.ToSQL(OpUpdateFields, Fields{"name": "go"}, byAuthor)
+	assert.Nil(t, err)
+	assert.Equal(t, "UPDATE `books` SET `name`=? WHERE `books`.`author_id` IN (?,?)", sql)
+	assert.Equal(t, []any{"go", "1", "2"}, args)
+
+	_, _, err = repo.ToSQL(OpDelete, nil)
+	assert.ErrorIs(t, err, gorm.ErrMissingWhereClause)
+	_, _, err = repo.ToSQL(OpUpdateFields, map[string]any{"name": "go"})
+	assert.NotNil(t, err)
+}
+
+func TestToSQL_Versioned(t *testing.T) {
+	repo := New(sqliteDB(t, &Doc{}), &Doc{}).(SQLCompiler)
+	sql, args, err := repo.ToSQL(OpUpdateFields, Fields{"title": "b", "version": 3}, func(opts *MatchOptions, schema Schema) {
+		opts.EQ(schema.Field("id"), 1)
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "UPDATE `docs` SET `title`=?,`version`=`version` + 1 WHERE `docs`.`id` = ? AND `version` = ?", sql)
+	assert.Equal(t, []any{"b", 1, 3}, args)
+}
@@ -0,0 +1,154 @@
+// Copyright (c) 2024 Yang,Zhong
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+package repository
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"gorm.io/gorm"
+)
+
+type txKey struct{}
+
+type txHooksKey struct{}
+
+// txHooks is the after commit hooks of a transaction started by WithTx
+type txHooks struct {
+	mu    sync.Mutex
+	hooks []func(ctx context.Context) error
+}
+
+func (h *txHooks) add(hooks ...func(ctx context.Context) error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.hooks = append(h.hooks, hooks...)
+}
+
+func (h *txHooks) run(ctx context.Context) error {
+	h.mu.Lock()
+	hooks := h.hooks
+	h.hooks = nil
+	h.mu.Unlock()
+	var err error
+	for _, hook := range hooks {
+		if herr := hook(ctx); herr != nil && err == nil {
+			err = herr
+		}
+	}
+	return err
+}
+
+type Transactor interface {
+	// WithTx run fn in a transaction, the transaction is carried by the ctx passed to fn,
+	// it's committed when fn returns nil and rolled back when fn returns error or panics.
+	// calling WithTx with a ctx which already carries a transaction creates a savepoint.
+	// the hooks registered by AfterCommit run after the outermost transaction commits, the
+	// error of WithTx is the first error of them then. a savepoint never runs the hooks, they
+	// are handed to the owner of the transaction, which is the outer WithTx or the caller by
+	// ContextWithAfterCommit, the savepoint is rolled back with ErrUnsupported if there's none
+	WithTx(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+type gormTransactor struct {
+	db *gorm.DB
+}
+
+// NewTransactor
+// usage:
+//
+//	tr := NewTransactor(db)
+//	users, books := New(db, &User{}), New(db, &Book{})
+//	err := tr.WithTx(ctx, func(ctx context.Context) error {
+//		if err := users.Create(ctx, &user); err != nil {
+//			return err
+//		}
+//		return books.Create(ctx, &book)
+//	})
+func NewTransactor(db *gorm.DB) Transactor {
+	return &gormTransactor{db: db}
+}
+
+func (t *gormTransactor) WithTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	db, savepoint := TxFromContext(ctx)
+	if !savepoint {
+		db = t.db
+	}
+	owner, owned := ctx.Value(txHooksKey{}).(*txHooks)
+	hooks := &txHooks{}
+	err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := fn(context.WithValue(ContextWithTx(ctx, tx), txHooksKey{}, hooks)); err != nil {
+			return err
+		}
+		// the hooks can't run before the transaction the savepoint belongs to commits
+		if savepoint && !owned && len(hooks.hooks) > 0 {
+			return fmt.Errorf("%w: after commit hooks in a transaction which isn't started by WithTx, bind the hooks by ContextWithAfterCommit", ErrUnsupported)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if savepoint {
+		if owned {
+			owner.add(hooks.hooks...)
+		}
+		return nil
+	}
+	return hooks.run(ctx)
+}
+
+// AfterCommit run fn after the transaction carried by ctx commits, fn is dropped if the transaction
+// rolls back. fn runs immediately when ctx carries neither a transaction started by WithTx nor the
+// hooks bound by ContextWithAfterCommit
+// usage:
+//
+//	err := tr.WithTx(ctx, func(ctx context.Context) error {
+//		if err := users.Create(ctx, &user); err != nil {
+//			return err
+//		}
+//		return AfterCommit(ctx, func(ctx context.Context) error {
+//			return mailer.Welcome(ctx, user)
+//		})
+//	})
+func AfterCommit(ctx context.Context, fn func(ctx context.Context) error) error {
+	if hooks, ok := ctx.Value(txHooksKey{}).(*txHooks); ok {
+		hooks.add(fn)
+		return nil
+	}
+	return fn(ctx)
+}
+
+// ContextWithAfterCommit collect the hooks registered by AfterCommit, including the ones of the
+// savepoints created by WithTx, for the caller which owns the transaction. the caller runs them
+// by commit after the transaction commits, commit returns the first error of them
+// usage:
+//
+//	tx := db.Begin()
+//	ctx, commit := ContextWithAfterCommit(ContextWithTx(ctx, tx))
+//	if err := tr.WithTx(ctx, fn); err != nil {
+//		tx.Rollback()
+//		return err
+//	}
+//	if err := tx.Commit().Error; err != nil {
+//		return err
+//	}
+//	return commit(context.Background())
+func ContextWithAfterCommit(ctx context.Context) (context.Context, func(ctx context.Context) error) {
+	hooks := &txHooks{}
+	return context.WithValue(ctx, txHooksKey{}, hooks), hooks.run
+}
+
+// ContextWithTx bind the transaction to the ctx, the repositories created by New and NewWithTable join it
+func ContextWithTx(ctx context.Context, tx *gorm.DB) context.Context {
+	return context.WithValue(ctx, txKey{}, tx)
+}
+
+// TxFromContext get the transaction bound to the ctx
+func TxFromContext(ctx context.Context) (*gorm.DB, bool) {
+	tx, ok := ctx.Value(txKey{}).(*gorm.DB)
+	return tx, ok && tx != nil
+}
@@ -0,0 +1,161 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransactor_WithTx(t *testing.T) {
+	db := sqliteDB(t, &Book{})
+	repo := New(db, &Book{})
+	tr := NewTransactor(db)
+	ctx := context.Background()
+	count := func() int64 {
+		var count int64
+		assert.Nil(t, repo.Count(ctx, &count))
+		return count
+	}
+	err := tr.WithTx(ctx, func(ctx context.Context) error {
+		return repo.Create(ctx, &Book{ID: "1"})
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), count())
+	rollback := errors.New("rollback")
+	err = tr.WithTx(ctx, func(ctx context.Context) error {
+		if err := repo.Create(ctx, &Book{ID: "2"}); err != nil {
+			return err
+		}
+		return rollback
+	})
+	assert.Equal(t, rollback, err)
+	assert.Equal(t, int64(1), count())
+	assert.Panics(t, func() {
+		_ = tr.WithTx(ctx, func(ctx context.Context) error {
+			_ = repo.Create(ctx, &Book{ID: "3"})
+			panic("oops")
+		})
+	})
+	assert.Equal(t, int64(1), count())
+}
+
+func TestTransactor_Savepoint(t *testing.T) {
+	db := sqliteDB(t, &Book{})
+	repo := New(db, &Book{})
+	ctx := context.Background()
+	err := repo.(Transactor).WithTx(ctx, func(ctx context.Context) error {
+		if err := repo.Create(ctx, &Book{ID: "1"}); err != nil {
+			return err
+		}
+		err := repo.(Transactor).WithTx(ctx, func(ctx context.Context) error {
+			if err := repo.Create(ctx, &Book{ID: "2"}); err != nil {
+				return err
+			}
+			return errors.New("rollback to savepoint")
+		})
+		assert.NotNil(t, err)
+		return repo.Create(ctx, &Book{ID: "3"})
+	})
+	assert.Nil(t, err)
+	var books []Book
+	assert.Nil(t, repo.Find(ctx, &books, func(opts *MatchOptions, schema Schema) { opts.SetSort("id") }))
+	assert.Equal(t, []Book{{ID: "1"}, {ID: "3"}}, books)
+}
+
+func TestAfterCommit(t *testing.T) {
+	db := sqliteDB(t, &Book{})
+	repo := New(db, &Book{})
+	ctx := context.Background()
+	var committed []string
+	hook := func(name string) func(context.Context) error {
+		return func(ctx context.Context) error {
+			_, inTx := TxFromContext(ctx)
+			assert.False(t, inTx)
+			committed = append(committed, name)
+			return nil
+		}
+	}
+	assert.Nil(t, AfterCommit(ctx, hook("immediately")))
+	err := repo.(Transactor).WithTx(ctx, func(ctx context.Context) error {
+		assert.Nil(t, AfterCommit(ctx, hook("outer")))
+		_ = repo.(Transactor).WithTx(ctx, func(ctx context.Context) error {
+			assert.Nil(t, AfterCommit(ctx, hook("rolled back")))
+			return errors.New("rollback to savepoint")
+		})
+		err := repo.(Transactor).WithTx(ctx, func(ctx context.Context) error {
+			return AfterCommit(ctx, hook("savepoint"))
+		})
+		assert.Equal(t, []string{"immediately"}, committed)
+		return err
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"immediately", "outer", "savepoint"}, committed)
+	failed := errors.New("failed")
+	err = repo.(Transactor).WithTx(ctx, func(ctx context.Context) error {
+		if err := repo.Create(ctx, &Book{ID: "1"}); err != nil {
+			return err
+		}
+		return AfterCommit(ctx, func(ctx context.Context) error { return failed })
+	})
+	assert.Equal(t, failed, err)
+	var count int64
+	assert.Nil(t, repo.Count(ctx, &count))
+	assert.Equal(t, int64(1), count)
+}
+
+func TestAfterCommit_OwnedTx(t *testing.T) {
+	db := sqliteDB(t, &Book{})
+	repo := New(db, &Book{})
+	ctx := context.Background()
+	var committed []string
+	hook := func(ctx context.Context) error {
+		committed = append(committed, "savepoint")
+		return nil
+	}
+	// the savepoint of a transaction bound by ContextWithTx can't run the hooks
+	tx := db.Begin()
+	err := repo.(Transactor).WithTx(ContextWithTx(ctx, tx), func(ctx context.Context) error {
+		if err := repo.Create(ctx, &Book{ID: "1"}); err != nil {
+			return err
+		}
+		return AfterCommit(ctx, hook)
+	})
+	assert.True(t, errors.Is(err, ErrUnsupported))
+	assert.Empty(t, committed)
+	var count int64
+	assert.Nil(t, repo.Count(ContextWithTx(ctx, tx), &count))
+	assert.Equal(t, int64(0), count)
+	assert.Nil(t, tx.Rollback().Error)
+
+	tx = db.Begin()
+	txCtx, commit := ContextWithAfterCommit(ContextWithTx(ctx, tx))
+	err = repo.(Transactor).WithTx(txCtx, func(ctx context.Context) error {
+		if err := repo.Create(ctx, &Book{ID: "1"}); err != nil {
+			return err
+		}
+		return AfterCommit(ctx, hook)
+	})
+	assert.Nil(t, err)
+	assert.Empty(t, committed)
+	assert.Nil(t, tx.Commit().Error)
+	assert.Nil(t, commit(ctx))
+	assert.Equal(t, []string{"savepoint"}, committed)
+	assert.Nil(t, commit(ctx))
+	assert.Equal(t, []string{"savepoint"}, committed)
+}
+
+func TestGormStack(t *testing.T) {
+	db := sqliteDB(t, &Book{})
+	repo := New(db, &Book{})
+	ctx := context.Background()
+	tx := db.Begin()
+	repo.(GormStack).Push(tx)
+	assert.Nil(t, repo.Create(ctx, &Book{ID: "1"}))
+	repo.(GormStack).Pop()
+	assert.Nil(t, tx.Rollback().Error)
+	var count int64
+	assert.Nil(t, repo.Count(ctx, &count))
+	assert.Equal(t, int64(0), count)
+}
@@ -0,0 +1,85 @@
+// Copyright (c) 2024 Yang,Zhong
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+package repository
+
+import (
+	"context"
+	"reflect"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type UpsertResult struct {
+	// Affected is the rows affected reported by the db
+	Affected int64
+	// Known tells whether Inserted and Updated are reported by the dialect
+	Known    bool
+	Inserted int64
+	Updated  int64
+}
+
+// Upsert insert the records of v, the conflicted records are updated with updateFields,
+// all the fields except the conflict columns are updated if updateFields is empty.
+// the conflict columns default to the primary key, they are ignored by mysql which uses
+// all the unique keys. on mysql the counts are derived from the affected rows, which
+// assumes every conflicted record is changed by the update
+// usage:
+//
+//	res, err := repo.Upsert(ctx, []*User{{Email: "a@b.c", Name: "a"}}, []string{"email"}, []string{"name"})
+func (db *dbrepo) Upsert(ctx context.Context, v any, conflictColumns, updateFields []string) (UpsertResult, error) {
+	ctx, cancel := db.options.context(ctx)
+	defer cancel()
+	var res UpsertResult
+	err := db.observe(ctx, "Upsert", nil, func(ctx context.Context) (int64, error) {
+		err := db.upsert(ctx, v, conflictColumns, updateFields, &res)
+		return res.Affected, db.transformError(ctx, err)
+	})
+	return res, err
+}
+
+func (db *dbrepo) upsert(ctx context.Context, v any, conflictColumns, updateFields []string, res *UpsertResult) error {
+	return db.outbox(ctx, Upserted, v, nil, func(ctx context.Context) error {
+		onConflict := clause.OnConflict{}
+		if len(conflictColumns) == 0 {
+			stmt := &gorm.Statement{DB: db.db}
+			if err := stmt.Parse(v); err != nil {
+				return err
+			}
+			conflictColumns = stmt.Schema.PrimaryFieldDBNames
+		}
+		for _, column := range conflictColumns {
+			onConflict.Columns = append(onConflict.Columns, clause.Column{Name: column})
+		}
+		if len(updateFields) > 0 {
+			onConflict.DoUpdates = clause.AssignmentColumns(updateFields)
+		} else {
+			onConflict.UpdateAll = true
+		}
+		tx := db.getDB(ctx).Clauses(onConflict).Create(v)
+		if tx.Error != nil {
+			return tx.Error
+		}
+		res.Affected = tx.RowsAffected
+		if db.db.Dialector.Name() == "mysql" {
+			// an inserted row affects 1 row and an updated row affects 2 rows
+			n := int64(upsertLen(v))
+			res.Inserted, res.Updated = 2*n-res.Affected, res.Affected-n
+			res.Known = res.Inserted >= 0 && res.Updated >= 0
+			if !res.Known {
+				res.Inserted, res.Updated = 0, 0
+			}
+		}
+		return nil
+	})
+}
+
+func upsertLen(v any) int {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array {
+		return rv.Len()
+	}
+	return 1
+}
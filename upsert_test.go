@@ -0,0 +1,56 @@
+package repository
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func testUpsert(t *testing.T, repo RDBRepository) UpsertResult {
+	ctx := context.Background()
+	assert.Nil(t, repo.Create(ctx, []*Member{{Name: "a", Age: 10}, {Name: "b", Age: 20}}))
+	res, err := repo.Upsert(ctx, []*Member{{ID: 1, Name: "x", Age: 99}, {Name: "c", Age: 30}}, []string{"id"}, []string{"name"})
+	assert.Nil(t, err)
+	var members []Member
+	assert.Nil(t, repo.Find(ctx, &members, func(opts *MatchOptions, schema Schema) { opts.SetSort("id") }))
+	assert.Equal(t, []Member{{ID: 1, Name: "x", Age: 10}, {ID: 2, Name: "b", Age: 20}, {ID: 3, Name: "c", Age: 30}}, members)
+	_, err = repo.Upsert(ctx, &Member{ID: 2, Name: "y", Age: 21}, nil, nil)
+	assert.Nil(t, err)
+	var member Member
+	assert.Nil(t, repo.First(ctx, &member, func(opts *MatchOptions, schema Schema) { opts.EQ(schema.Field("id"), 2) }))
+	assert.Equal(t, Member{ID: 2, Name: "y", Age: 21}, member)
+	return res
+}
+
+func TestUpsert(t *testing.T) {
+	res := testUpsert(t, New(sqliteDB(t, &Member{}), &Member{}))
+	assert.Equal(t, UpsertResult{Affected: 2}, res)
+}
+
+func TestMemoryRepository_Upsert(t *testing.T) {
+	res := testUpsert(t, NewMemory(&Member{}))
+	assert.Equal(t, UpsertResult{Affected: 2, Known: true, Inserted: 1, Updated: 1}, res)
+}
+
+func TestGormRepository_Upsert(t *testing.T) {
+	db, mock, err := sqlmock.New() // mock sql.DB
+	assert.Nil(t, err)
+	defer db.Close()
+	defer assert.Nil(t, mock.ExpectationsWereMet())
+	gdb, err := gorm.Open(dialector(db)) // open gorm db
+	assert.Nil(t, err)
+	repo := New(gdb, &Book{})
+	func() {
+		mock.ExpectBegin()
+		execSql := "^INSERT INTO `books` \\(`id`,`name`,`author_id`\\) VALUES \\(\\?,\\?,\\?\\),\\(\\?,\\?,\\?\\) ON DUPLICATE KEY UPDATE `name`=VALUES\\(`name`\\)$"
+		mock.ExpectExec(execSql).
+			WithArgs("1", "a", "", "2", "b", "").WillReturnResult(sqlmock.NewResult(0, 3))
+		mock.ExpectCommit()
+	}()
+	res, err := repo.Upsert(context.Background(), []*Book{{ID: "1", Name: "a"}, {ID: "2", Name: "b"}}, []string{"id"}, []string{"name"})
+	assert.Nil(t, err)
+	assert.Equal(t, UpsertResult{Affected: 3, Known: true, Inserted: 1, Updated: 1}, res)
+}
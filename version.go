@@ -0,0 +1,112 @@
+// Copyright (c) 2024 Yang,Zhong
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+package repository
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"gorm.io/gorm"
+	gschema "gorm.io/gorm/schema"
+)
+
+// versionField find the integer field tagged with `repository:"version"`, the records with it are
+// updated only if the version is not changed since they are read, and the version is incremented.
+//
+//	type Book struct {
+//		ID      string
+//		Name    string
+//		Version int `repository:"version"`
+//	}
+func versionField(s *gschema.Schema) *gschema.Field {
+	for _, f := range s.Fields {
+		if f.Tag.Get("repository") == "version" {
+			return f
+		}
+	}
+	return nil
+}
+
+// versionOf get the version of the record
+func versionOf(ctx context.Context, f *gschema.Field, rv reflect.Value) (int64, error) {
+	v, _ := f.ValueOf(ctx, rv)
+	version, ok := memValue(v).(int64)
+	if !ok {
+		return 0, fmt.Errorf("version field %s must be an integer, got %T", f.Name, v)
+	}
+	return version, nil
+}
+
+// expectedVersion pick the version out of fields, the version given in fields is the one
+// the record is expected to have
+func expectedVersion(f *gschema.Field, fields Fields) (Fields, any, bool) {
+	ret := make(Fields, len(fields))
+	var expected any
+	var ok bool
+	for k, v := range fields {
+		if k == f.DBName || k == f.Name {
+			expected, ok = v, true
+			continue
+		}
+		ret[k] = v
+	}
+	return ret, expected, ok
+}
+
+func (repo *dbrepo) versionField(v any) *gschema.Field {
+	if v == nil {
+		return nil
+	}
+	if _, ok := v.(string); ok {
+		return nil
+	}
+	stmt := &gorm.Statement{DB: repo.db}
+	if err := stmt.Parse(v); err != nil {
+		return nil
+	}
+	return versionField(stmt.Schema)
+}
+
+// updateVersioned update the record where the version is unchanged and increment the version,
+// the record with zero primary key is created like Save does
+func (repo *dbrepo) updateVersioned(ctx context.Context, saver *gorm.DB, f *gschema.Field, v any) error {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if !rv.CanAddr() {
+		return fmt.Errorf("update a versioned record requires a pointer, got %T", v)
+	}
+	for _, pf := range f.Schema.PrimaryFields {
+		if _, zero := pf.ValueOf(ctx, rv); zero {
+			return saver.Save(v).Error
+		}
+	}
+	version, err := versionOf(ctx, f, rv)
+	if err != nil {
+		return err
+	}
+	if err := f.Set(ctx, rv, version+1); err != nil {
+		return err
+	}
+	tx := saver.Model(v).Select("*").Where(repo.schema().Quote(f.DBName)+" = ?", version).Updates(v)
+	if tx.Error == nil && tx.RowsAffected == 0 {
+		tx.Error = ErrStaleRecord
+	}
+	if tx.Error != nil {
+		_ = f.Set(ctx, rv, version)
+	}
+	return tx.Error
+}
+
+// updateFieldsVersioned increment the version of the records, if the version is given in
+// fields, only the records of the version are updated, the caller reports ErrStaleRecord if none is
+func (repo *dbrepo) updateFieldsVersioned(updator *gorm.DB, f *gschema.Field, fields Fields) (*gorm.DB, bool) {
+	fields, expected, ok := expectedVersion(f, fields)
+	column := repo.schema().Quote(f.DBName)
+	if ok {
+		updator.Where(column+" = ?", expected)
+	}
+	fields[f.DBName] = gorm.Expr(column + " + 1")
+	return updator.Updates(map[string]any(fields)), ok
+}
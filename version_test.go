@@ -0,0 +1,50 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type Doc struct {
+	ID      uint
+	Title   string
+	Version int `repository:"version"`
+}
+
+func testVersion(t *testing.T, repo RDBRepository) {
+	ctx := context.Background()
+	id := func(opts *MatchOptions, schema Schema) { opts.EQ(schema.Field("id"), 1) }
+	assert.Nil(t, repo.Create(ctx, &Doc{Title: "a"}))
+	var a, b, doc Doc
+	assert.Nil(t, repo.First(ctx, &a, id))
+	assert.Nil(t, repo.First(ctx, &b, id))
+	a.Title, b.Title = "x", "y"
+	assert.Nil(t, repo.Update(ctx, &a))
+	assert.Equal(t, 1, a.Version)
+	assert.True(t, errors.Is(repo.Update(ctx, &b), ErrStaleRecord))
+	assert.Equal(t, 0, b.Version)
+	assert.Nil(t, repo.First(ctx, &doc, id))
+	assert.Equal(t, Doc{ID: 1, Title: "x", Version: 1}, doc)
+
+	assert.Nil(t, repo.UpdateFields(ctx, Fields{"title": "z"}, id))
+	assert.True(t, errors.Is(repo.UpdateFields(ctx, Fields{"title": "w", "version": 1}, id), ErrStaleRecord))
+	assert.Nil(t, repo.UpdateFields(ctx, Fields{"title": "w", "version": 2}, id))
+	assert.Nil(t, repo.First(ctx, &doc, id))
+	assert.Equal(t, Doc{ID: 1, Title: "w", Version: 3}, doc)
+
+	assert.True(t, errors.Is(repo.Update(ctx, &Doc{ID: 99, Title: "missing"}), ErrStaleRecord))
+	created := Doc{Title: "new"}
+	assert.Nil(t, repo.Update(ctx, &created))
+	assert.Equal(t, uint(2), created.ID)
+}
+
+func TestVersion(t *testing.T) {
+	testVersion(t, New(sqliteDB(t, &Doc{}), &Doc{}))
+}
+
+func TestMemoryRepository_Version(t *testing.T) {
+	testVersion(t, NewMemory(&Doc{}))
+}